@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+var maxBodySize = flag.Int64("max-body-size", 2<<20, "bytes read from a page at most, checks may set a lower limit")
+
+// Body of a fetched page, read up to the size limit of the check.
+type Body struct {
+	Text      string
+	Size      int64
+	Truncated bool
+
+	// Hash of the whole body and whether it contains the selector, worked
+	// out while reading
+	Hash     string
+	Contains bool
+}
+
+// BodyLimit is the size limit of the check, never above the global one.
+func (c *Check) BodyLimit() int64 {
+	if c.MaxBodySize > 0 && (*maxBodySize <= 0 || c.MaxBodySize < *maxBodySize) {
+		return c.MaxBodySize
+	}
+	return *maxBodySize
+}
+
+// readBody reads r up to the size limit of the check, hashing it and
+// looking for the selector on the way.
+func (c *Check) readBody(r io.Reader) (*Body, error) {
+	limit := c.BodyLimit()
+	page := r
+	if limit > 0 {
+		r = io.LimitReader(page, limit)
+	}
+
+	hash := sha256.New()
+	search := &containsWriter{selector: []byte(c.Selector)}
+	var text bytes.Buffer
+
+	size, err := io.Copy(io.MultiWriter(hash, search, &text), r)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &Body{
+		Text:     text.String(),
+		Size:     size,
+		Hash:     hex.EncodeToString(hash.Sum(nil)),
+		Contains: search.found,
+	}
+
+	// Anything left means the limit cut the page
+	if limit > 0 && size == limit {
+		var probe [1]byte
+		if n, _ := io.ReadFull(page, probe[:]); n > 0 {
+			body.Truncated = true
+		}
+	}
+	return body, nil
+}
+
+// containsWriter looks for the selector in the written chunks, keeping the
+// end of the previous chunk for matches across them.
+type containsWriter struct {
+	selector []byte
+	tail     []byte
+	found    bool
+}
+
+func (w *containsWriter) Write(p []byte) (int, error) {
+	if w.found || len(w.selector) == 0 {
+		w.found = true
+		return len(p), nil
+	}
+
+	chunk := append(w.tail, p...)
+	if bytes.Contains(chunk, w.selector) {
+		w.found = true
+		w.tail = nil
+		return len(p), nil
+	}
+
+	keep := len(w.selector) - 1
+	if len(chunk) < keep {
+		keep = len(chunk)
+	}
+	w.tail = append([]byte{}, chunk[len(chunk)-keep:]...)
+	return len(p), nil
+}
+
+// loadContent reads the content of the last change from the latest
+// snapshot, which is always kept.
+func (c *Check) loadContent(db *bolt.DB) (content string) {
+	db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(HistoryBucket).Bucket(KeyFor(c.ID)); b != nil {
+			if snapshot := latestSnapshot(b); snapshot != nil {
+				content = snapshot.Body
+			}
+		}
+		return nil
+	})
+	return
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize reads sizes like 500KB or 2MB, a plain number is in bytes.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			unit = u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("wrong size, use bytes or KB, MB like 500KB")
+	}
+	return int64(n * float64(unit)), nil
+}
+
+func FormatSize(size int64) string {
+	for _, u := range sizeUnits {
+		if size >= u.bytes && size%u.bytes == 0 {
+			return fmt.Sprintf("%d%s", size/u.bytes, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// Longest diff put into chat messages, below the Slack limit of 3000
+	// characters for a block.
+	MaxChatDiffLength = 2800
+
+	colorFound    = 0x36a64f
+	colorNotFound = 0xd00000
+)
+
+// Posts events to Slack incoming webhooks as blocks. The webhook URL may
+// reference secrets, like the ones of all chat notifiers.
+type SlackNotifier struct {
+	DB *bolt.DB
+}
+
+// Posts events to Discord webhooks as embeds.
+type DiscordNotifier struct {
+	DB *bolt.DB
+}
+
+// Posts events to Mattermost incoming webhooks as attachments.
+type MattermostNotifier struct {
+	DB *bolt.DB
+}
+
+func (n *SlackNotifier) ValidateTarget(check *Check, target string) error {
+	return validateChatURL(target)
+}
+
+func (n *DiscordNotifier) ValidateTarget(check *Check, target string) error {
+	return validateChatURL(target)
+}
+
+func (n *MattermostNotifier) ValidateTarget(check *Check, target string) error {
+	return validateChatURL(target)
+}
+
+func validateChatURL(target string) error {
+	if !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://") {
+		return fmt.Errorf("please set the webhook url, like slack:https://hooks.slack.com/services/...")
+	}
+	return nil
+}
+
+func (n *SlackNotifier) Notify(event *Event, target string) error {
+	target, err := event.Check.expandSecrets(n.DB, target)
+	if err != nil {
+		return err
+	}
+
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+	text := fmt.Sprintf("*<%s|%s>* _%s_", escape.Replace(event.Check.URL), escape.Replace(event.Check.Title), event.State())
+	for _, field := range event.Fields() {
+		text += fmt.Sprintf("\n*%s:* %s", escape.Replace(field[0]), escape.Replace(field[1]))
+	}
+
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": text},
+		},
+	}
+
+	if diff := event.ChatDiff(); diff != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": "```" + escape.Replace(diff) + "```"},
+		})
+	}
+
+	return postJSON(target, map[string]interface{}{
+		"text":   fmt.Sprintf("%s %s", event.Check.Title, event.State()),
+		"blocks": blocks,
+	})
+}
+
+func (n *DiscordNotifier) Notify(event *Event, target string) error {
+	target, err := event.Check.expandSecrets(n.DB, target)
+	if err != nil {
+		return err
+	}
+
+	fields := []map[string]interface{}{}
+	for _, field := range event.Fields() {
+		fields = append(fields, map[string]interface{}{"name": field[0], "value": field[1], "inline": true})
+	}
+
+	embed := map[string]interface{}{
+		"title":     fmt.Sprintf("%s %s", event.Check.Title, event.State()),
+		"url":       event.Check.URL,
+		"color":     event.Color(),
+		"fields":    fields,
+		"timestamp": event.Time.Format(time.RFC3339),
+	}
+
+	if diff := event.ChatDiff(); diff != "" {
+		embed["description"] = "```\n" + diff + "\n```"
+	}
+
+	return postJSON(target, map[string]interface{}{
+		"embeds": []interface{}{embed},
+	})
+}
+
+func (n *MattermostNotifier) Notify(event *Event, target string) error {
+	target, err := event.Check.expandSecrets(n.DB, target)
+	if err != nil {
+		return err
+	}
+
+	fields := []map[string]interface{}{}
+	for _, field := range event.Fields() {
+		fields = append(fields, map[string]interface{}{"title": field[0], "value": field[1], "short": true})
+	}
+
+	attachment := map[string]interface{}{
+		"fallback":   fmt.Sprintf("%s %s", event.Check.Title, event.State()),
+		"color":      fmt.Sprintf("#%06x", event.Color()),
+		"title":      fmt.Sprintf("%s %s", event.Check.Title, event.State()),
+		"title_link": event.Check.URL,
+		"fields":     fields,
+	}
+
+	if diff := event.ChatDiff(); diff != "" {
+		attachment["text"] = "```\n" + diff + "\n```"
+	}
+
+	return postJSON(target, map[string]interface{}{
+		"attachments": []interface{}{attachment},
+	})
+}
+
+// Fields lists the error, value and captures of the event as name, value
+// pairs.
+func (e *Event) Fields() (fields [][2]string) {
+	if e.Error != "" {
+		fields = append(fields, [2]string{"error", e.Error})
+	}
+
+	if e.Value != nil {
+		value := FormatNumber(*e.Value)
+		if e.LastValue != nil {
+			value += fmt.Sprintf(" (was %s)", FormatNumber(*e.LastValue))
+		}
+		fields = append(fields, [2]string{"value", value})
+	}
+
+	for _, capture := range e.Captures {
+		fields = append(fields, [2]string{capture.Name, capture.Value})
+	}
+	return
+}
+
+func (e *Event) ChatDiff() string {
+	if e.OldText == "" {
+		return ""
+	}
+
+	// Code blocks can't be closed from inside
+	return strings.Replace(DiffText(e.OldText, e.NewText, MaxChatDiffLength), "```", "'''", -1)
+}
+
+func (e *Event) Color() int {
+	switch e.Kind {
+	case EventDown:
+		return colorNotFound
+	case EventUp:
+		return colorFound
+	}
+
+	if e.Found {
+		return colorFound
+	}
+	return colorNotFound
+}
+
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doRequest(req)
+}
@@ -5,35 +5,69 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/robfig/cron"
 )
 
+// Returned when a check was edited by someone else since it was read.
+var ErrConflict = errors.New("the check was changed meanwhile, please try again")
+
 // Helper struct for serialization.
 type Check struct {
-	ID                 uint64    `json:"id"`
-	UserID             uint64    `json:"user_id"`
-	URL                string    `json:"url"`
-	Selector           string    `json:"selector"`
-	Schedule           string    `json:"schedule"`
-	LastChecked        time.Time `json:"last_checked"`
-	LastChanged        time.Time `json:"last_changed"`
-	LastHash           string    `json:"last_hash"`
-	Content            string    `json:"content"`
-	Title              string    `json:"title"`
-	IsRecovered        bool      `json:"is_recovered"`
-	AlertOnlyRecovered bool      `json:"alert_recovered"`
-	AlertIfPresent     bool      `json:"is_present"`
-	IsEnabled          bool      `json:"is_enabled"`
+	ID                 uint64            `json:"id"`
+	UserID             uint64            `json:"user_id"`
+	URL                string            `json:"url"`
+	Selector           string            `json:"selector"`
+	Matcher            string            `json:"matcher"`
+	Attribute          string            `json:"attribute"`
+	Value              string            `json:"value"`
+	Operator           string            `json:"operator"`
+	Threshold          string            `json:"threshold"`
+	HistoryLimit       int               `json:"history_limit"`
+	HistoryDays        int               `json:"history_days"`
+	Notifiers          []string          `json:"notifiers"`
+	Template           string            `json:"template"`
+	Method             string            `json:"method"`
+	Headers            map[string]string `json:"headers"`
+	Body               string            `json:"body"`
+	ContentType        string            `json:"content_type"`
+	LoginURL           string            `json:"login_url"`
+	LoginCredentials   string            `json:"login_credentials"`
+	NetworkThreshold   int               `json:"network_threshold"`
+	StatusThreshold    int               `json:"status_threshold"`
+	NetworkFailures    int               `json:"network_failures"`
+	StatusFailures     int               `json:"status_failures"`
+	IsDown             bool              `json:"is_down"`
+	LastError          string            `json:"last_error"`
+	LastErrorAt        time.Time         `json:"last_error_at"`
+	Schedule           string            `json:"schedule"`
+	LastChecked        time.Time         `json:"last_checked"`
+	LastChanged        time.Time         `json:"last_changed"`
+	LastHash           string            `json:"last_hash"`
+	ETag               string            `json:"etag"`
+	LastModified       string            `json:"last_modified"`
+	LastValue          *float64          `json:"last_value"`
+	Content            string            `json:"-"`
+	Truncated          bool              `json:"truncated"`
+	MaxBodySize        int64             `json:"max_body_size"`
+	Title              string            `json:"title"`
+	IsRecovered        bool              `json:"is_recovered"`
+	AlertOnlyRecovered bool              `json:"alert_recovered"`
+	AlertIfPresent     bool              `json:"is_present"`
+	IsEnabled          bool              `json:"is_enabled"`
+
+	// Incremented by every edit of the settings, state written by Update
+	// doesn't count.
+	Revision uint64 `json:"revision"`
 
 	// SeenChange    bool      `json:"seen"`
 
@@ -41,6 +75,7 @@ type Check struct {
 	LastCheckedPretty string `json:"-"`
 	LastChangedPretty string `json:"-"`
 	IsEnabledPretty   string `json:"-"`
+	MatcherPretty     string `json:"-"`
 
 	// The first 8 characters of the hash
 	ShortHash string `json:"-"`
@@ -54,6 +89,8 @@ type User struct {
 	UserID      int64     `json:"user_id"`
 	LastChanged time.Time `json:"last_changed"`
 	IsEnabled   bool      `json:"is_enabled"`
+	Email       string    `json:"email"`
+	Template    string    `json:"template"`
 
 	// TODO: The last-checked date, as a string.
 	LastChangedPretty string `json:"-"`
@@ -101,6 +138,12 @@ func (c *Check) PrepareForDisplay() {
 		c.IsEnabledPretty = "disabled"
 	}
 
+	if c.Matcher == "" {
+		c.MatcherPretty = MatcherContains
+	} else {
+		c.MatcherPretty = c.Matcher
+	}
+
 	if len(c.URL) > 0 {
 		u, err := url.Parse(c.URL)
 		if err == nil {
@@ -163,38 +206,86 @@ func (c *Check) Update(db *bolt.DB) {
 		Timeout: timeout,
 	}
 
-	resp, err := client.Get(c.URL)
+	resp, err := c.fetch(db, &client)
 	if err != nil {
-		println("error fetching check", c.ID, c.URL, err)
+		c.fetchFailed(db, FailureNetwork, failureReason(err))
 		return
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		// Nothing to match, the content is the one of the last fetch
+		c.fetchSucceeded()
+		c.LastChecked = time.Now()
+		if err = c.save(db, nil); err != nil {
+			println("error saving check", c.ID, err.Error())
+		}
+		return
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		println("error status check", c.ID, c.URL, resp.StatusCode)
+		c.fetchFailed(db, FailureStatus, fmt.Sprintf("HTTP status %d", resp.StatusCode))
 		return
 	}
 
-	test, err := ioutil.ReadAll(resp.Body)
+	body, err := c.readBody(resp.Body)
 	if err != nil {
-		println("error", err.Error())
+		c.fetchFailed(db, FailureNetwork, failureReason(err))
 		return
 	}
 
-	text := string(test) //Short(string(test), 81920)
-	c.Content = text
+	c.Truncated = body.Truncated
+	if body.Truncated {
+		println("body of check", c.ID, "truncated at", body.Size, "bytes")
+	}
+
+	// Plain searches were done while reading the body
+	match := MatchResult{Found: body.Contains, Text: body.Text}
+	sum := body.Hash
+	if c.Matcher != "" && c.Matcher != MatcherContains {
+		if match, err = c.Match(body.Text); err != nil {
+			c.fetchFailed(db, FailureStatus, "error matching: "+err.Error())
+			return
+		}
+
+		hash := sha256.New()
+		io.WriteString(hash, match.Text)
+		sum = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	// Previous value is kept for the alert before it is replaced
+	lastValue := c.LastValue
+	if c.Threshold != "" {
+		value, err := NumberFrom(match)
+		if err != nil {
+			c.fetchFailed(db, FailureStatus, "error reading number: "+err.Error())
+			return
+		}
+
+		if match.Found, err = c.ThresholdReached(value); err != nil {
+			c.fetchFailed(db, FailureStatus, "error checking threshold: "+err.Error())
+			return
+		}
+		c.LastValue = &value
+	}
+
+	// Only a page that could be matched counts as a recovery, the state
+	// is saved below
+	c.fetchSucceeded()
+	c.ETag = resp.Header.Get("ETag")
+	c.LastModified = resp.Header.Get("Last-Modified")
 
-	// Hash the content
-	hash := sha256.New()
-	io.WriteString(hash, text)
-	sum := hex.EncodeToString(hash.Sum(nil))
+	text := match.Text
 
 	// Check for update
+	var snapshot *Snapshot
 	if c.LastHash != sum {
-		contains := strings.Contains(text, c.Selector)
+		contains := match.Found
 
 		oldRecovered := c.IsRecovered
+		previous := c.loadContent(db)
+		c.Content = text
 
 		if !c.IsRecovered && contains && c.AlertIfPresent {
 			c.IsRecovered = true
@@ -208,31 +299,46 @@ func (c *Check) Update(db *bolt.DB) {
 			}
 		}
 
-		message := ""
+		alert := false
+		if (c.AlertIfPresent && contains) || (!c.AlertIfPresent && !contains) {
+			alert = !c.AlertOnlyRecovered || c.IsRecovered != oldRecovered
+		}
+
+		if alert {
+			check := *c
 
-		if c.AlertIfPresent && contains {
-			if c.AlertOnlyRecovered {
-				if c.IsRecovered != oldRecovered {
-					message = fmt.Sprintf("/%d <b>%s</b> <i>found</i>", c.ID, c.Title)
-				}
-			} else {
-				message = fmt.Sprintf("/%d <b>%s</b> <i>found</i>", c.ID, c.Title)
-			}
-		} else if !c.AlertIfPresent && !contains {
-			if c.AlertOnlyRecovered {
-				if c.IsRecovered != oldRecovered {
-					message = fmt.Sprintf("/%d <b>%s</b> <i>NOT found</i>", c.ID, c.Title)
-				}
-			} else {
-				message = fmt.Sprintf("/%d <b>%s</b> <i>NOT found</i>", c.ID, c.Title)
+			user := &User{}
+			userTemplate := ""
+			if user = user.Get(db, c.UserID); user != nil {
+				userTemplate = user.Template
 			}
-		}
 
-		if message != "" {
-			telegramChan <- telegramResponse{message, int64(c.UserID), int64(c.ID)}
+			Notify(&Event{
+				Check:        &check,
+				Kind:         EventChange,
+				Found:        contains,
+				Status:       resp.StatusCode,
+				UserTemplate: userTemplate,
+				Captures:     match.Captures,
+				Value:        c.LastValue,
+				LastValue:    lastValue,
+				OldHash:      c.LastHash,
+				NewHash:      sum,
+				OldText:      previous,
+				NewText:      text,
+				Time:         time.Now(),
+			})
 		}
 		c.LastHash = sum
 		c.LastChanged = time.Now()
+
+		snapshot = &Snapshot{
+			Time:   c.LastChanged,
+			Hash:   sum,
+			Found:  contains,
+			Status: resp.StatusCode,
+			Body:   text,
+		}
 	} else {
 		// c.IsRecovered = false
 	}
@@ -243,20 +349,110 @@ func (c *Check) Update(db *bolt.DB) {
 
 	// Need to update the database now, since we've changed (at least the last
 	// checked time).
-	err = db.Update(func(tx *bolt.Tx) error {
-		data, err := json.Marshal(c)
+	if err = c.save(db, snapshot); err != nil {
+		println("error saving check", c.ID, err.Error())
+	}
+}
+
+// save stores the state of the check, along with a snapshot of its content
+// if given. Settings edited meanwhile are kept.
+func (c *Check) save(db *bolt.DB, snapshot *Snapshot) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(UrlsBucket)
+
+		data := b.Get(KeyFor(c.ID))
+		if data == nil {
+			// Deleted during the fetch
+			return nil
+		}
+
+		stored := &Check{}
+		if err := json.Unmarshal(data, stored); err != nil {
+			return err
+		}
+		stored.ID = c.ID
+		stored.copyState(c)
+		if stored.Revision != c.Revision {
+			// Fetched with old settings, the next fetch must be a full one
+			stored.ETag = ""
+			stored.LastModified = ""
+		}
+		if stored.Threshold != c.Threshold {
+			stored.LastValue = nil
+		}
+
+		data, err := json.Marshal(stored)
 		if err != nil {
 			return err
 		}
 
-		if err = tx.Bucket(UrlsBucket).Put(KeyFor(c.ID), data); err != nil {
+		if err = b.Put(KeyFor(c.ID), data); err != nil {
 			return err
 		}
+
+		if snapshot != nil {
+			return snapshot.Save(tx, stored)
+		}
 		return nil
 	})
 }
 
-func (c *Check) New(db *bolt.DB, cron *cron.Cron, url string, search string, contains string, userID int64) (result string) {
+// put stores the edited settings of the check, failing with ErrConflict
+// when another edit was stored since the check was read.
+func (c *Check) put(tx *bolt.Tx) error {
+	b := tx.Bucket(UrlsBucket)
+
+	data := b.Get(KeyFor(c.ID))
+	if data == nil {
+		return fmt.Errorf("no such check: %d", c.ID)
+	}
+
+	stored := &Check{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		return err
+	}
+
+	if stored.Revision != c.Revision {
+		return ErrConflict
+	}
+
+	c.copyState(stored)
+	c.Revision++
+
+	// A new threshold starts over from the next value read
+	if c.Threshold != stored.Threshold {
+		c.LastValue = nil
+	}
+
+	// The stored content may not match the new settings
+	c.ETag = ""
+	c.LastModified = ""
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return b.Put(KeyFor(c.ID), data)
+}
+
+// copyState takes the fields written by Update from other.
+func (c *Check) copyState(other *Check) {
+	c.LastChecked = other.LastChecked
+	c.LastChanged = other.LastChanged
+	c.LastHash = other.LastHash
+	c.LastValue = other.LastValue
+	c.Truncated = other.Truncated
+	c.IsRecovered = other.IsRecovered
+	c.NetworkFailures = other.NetworkFailures
+	c.StatusFailures = other.StatusFailures
+	c.IsDown = other.IsDown
+	c.LastError = other.LastError
+	c.LastErrorAt = other.LastErrorAt
+	c.ETag = other.ETag
+	c.LastModified = other.LastModified
+}
+
+func (c *Check) New(db *bolt.DB, scheduler *Scheduler, url string, matcher string, search string, contains string, userID int64) (result string) {
 	println("adding new check", url, search)
 
 	if len(url) == 0 {
@@ -265,6 +461,10 @@ func (c *Check) New(db *bolt.DB, cron *cron.Cron, url string, search string, con
 	if len(search) == 0 {
 		return "missing search parameter"
 	}
+	if err := ValidateMatcher(matcher, search); err != nil {
+		// Patterns like (?P<name>...) would read as HTML tags
+		return html.EscapeString(err.Error())
+	}
 
 	if len(contains) == 0 {
 		return "missing contains parameter"
@@ -277,7 +477,8 @@ func (c *Check) New(db *bolt.DB, cron *cron.Cron, url string, search string, con
 	check := Check{
 		URL:                url,
 		Selector:           search,
-		Schedule:           "0 * * * * *",
+		Matcher:            matcher,
+		Schedule:           DefaultSchedule,
 		UserID:             uint64(userID),
 		IsEnabled:          true,
 		IsRecovered:        false,
@@ -312,17 +513,16 @@ func (c *Check) New(db *bolt.DB, cron *cron.Cron, url string, search string, con
 	}
 
 	// If we succeeded, we update right now...
-	check.Update(db)
+	scheduler.pool.Update(db, &check)
 
 	// ... and add a new Cron callback
-	cr := cron
-	cr.AddFunc(check.Schedule, func() {
-		TryUpdate(db, check.ID)
-	})
+	if err = scheduler.Set(&check); err != nil {
+		return err.Error()
+	}
 	return fmt.Sprintf("/%d added", check.ID)
 }
 
-func (c *Check) Delete(db *bolt.DB, requester int64, findID string) (result bool) {
+func (c *Check) Delete(db *bolt.DB, scheduler *Scheduler, requester int64, findID string) (result bool) {
 	id, err := strconv.ParseUint(findID, 10, 64)
 	if err != nil {
 		println(err.Error(), http.StatusBadRequest)
@@ -354,16 +554,24 @@ func (c *Check) Delete(db *bolt.DB, requester int64, findID string) (result bool
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(UrlsBucket).Delete(KeyFor(id))
+		if err := tx.Bucket(UrlsBucket).Delete(KeyFor(id)); err != nil {
+			return err
+		}
+		if err := DeleteCookies(tx, id); err != nil {
+			return err
+		}
+		return DeleteSnapshots(tx, id)
 	})
 	if err != nil {
 		println(err.Error(), http.StatusInternalServerError)
 		return false
 	}
+
+	scheduler.Remove(id)
 	return true
 }
 
-func (c *Check) Info(db *bolt.DB, requester int64, findID string) (result string) {
+func (c *Check) Info(db *bolt.DB, scheduler *Scheduler, requester int64, findID string) (result string) {
 	id, err := strconv.ParseUint(findID, 10, 64)
 	if err != nil {
 		println(err.Error(), http.StatusBadRequest)
@@ -397,10 +605,60 @@ func (c *Check) Info(db *bolt.DB, requester int64, findID string) (result string
 
 	check.PrepareForDisplay()
 
-	return fmt.Sprintf("<b>%s</b>\n/%d from %d (%s)\nURL: %s\nSearch: %s\nlast checked: %s\nlast changed: %s\nMust contain string: %t\nAlert only after recover: %t", check.Title, check.ID, check.UserID, check.IsEnabledPretty, check.URL, check.Selector, check.LastCheckedPretty, check.LastChangedPretty, check.AlertIfPresent, check.AlertOnlyRecovered)
+	result = fmt.Sprintf("<b>%s</b>\n/%d from %d (%s)\nURL: %s\nSearch: %s\nMatcher: %s", html.EscapeString(check.Title), check.ID, check.UserID, check.IsEnabledPretty, html.EscapeString(check.URL), html.EscapeString(check.Selector), check.MatcherPretty)
+	if check.Attribute != "" {
+		result += fmt.Sprintf("\nAttribute: %s", html.EscapeString(check.Attribute))
+	}
+	if check.Operator != "" {
+		result += fmt.Sprintf("\nOperator: %s", check.Operator)
+	}
+	if check.Value != "" {
+		result += fmt.Sprintf("\nValue: %s", html.EscapeString(check.Value))
+	}
+	if check.Threshold != "" {
+		result += fmt.Sprintf("\nThreshold: %s", html.EscapeString(check.Threshold))
+		if check.LastValue != nil {
+			result += fmt.Sprintf("\nLast value: %s", FormatNumber(*check.LastValue))
+		}
+	}
+
+	if check.Method != "" {
+		result += fmt.Sprintf("\nMethod: %s", check.Method)
+	}
+	if len(check.Headers) > 0 {
+		result += fmt.Sprintf("\nHeaders:\n<code>%s</code>", html.EscapeString(check.HeadersPretty()))
+	}
+	if check.ContentType != "" {
+		result += fmt.Sprintf("\nContent type: %s", html.EscapeString(check.ContentType))
+	}
+	if check.Body != "" {
+		result += fmt.Sprintf("\nBody: <code>%s</code>", html.EscapeString(check.BodyPretty()))
+	}
+	if check.LoginURL != "" {
+		result += fmt.Sprintf("\nLogin: %s (credentials stored encrypted)", html.EscapeString(check.LoginURL))
+	}
+
+	result += fmt.Sprintf("\nMax size: %s", FormatSize(check.BodyLimit()))
+	if check.Truncated {
+		result += " (last page was truncated)"
+	}
+	result += fmt.Sprintf("\nHistory: %s", check.RetentionPretty())
+	result += fmt.Sprintf("\nFailures: %s", check.FailuresPretty())
+	if check.LastError != "" {
+		result += fmt.Sprintf("\nLast error: %s (%s)", html.EscapeString(check.LastError), check.LastErrorAt.Format("Jan 2, 2006 at 3:04pm (MST)"))
+	}
+
+	result += fmt.Sprintf("\nNotify: %s", html.EscapeString(check.NotifiersPretty()))
+
+	result += fmt.Sprintf("\nSchedule: %s", check.Schedule)
+	if next := scheduler.Next(check.ID); !next.IsZero() {
+		result += fmt.Sprintf("\nNext run: %s", next.Format("Jan 2, 2006 at 3:04:05pm (MST)"))
+	}
+
+	return result + fmt.Sprintf("\nlast checked: %s\nlast changed: %s\nMust contain string: %t\nAlert only after recover: %t", check.LastCheckedPretty, check.LastChangedPretty, check.AlertIfPresent, check.AlertOnlyRecovered)
 }
 
-func (c *Check) Modify(db *bolt.DB, requester int64, findID int64, title string, url string, search string, notifyPresent bool, isEnabled bool, onlyRecovered bool) (result string) {
+func (c *Check) Modify(db *bolt.DB, scheduler *Scheduler, requester int64, findID int64, title string, url string, search string, notifyPresent bool, isEnabled bool, onlyRecovered bool) (result string) {
 	// id, err := strconv.ParseUint(findID, 10, 64)
 	// if err != nil {
 	// 	println(err.Error(), http.StatusBadRequest)
@@ -439,6 +697,10 @@ func (c *Check) Modify(db *bolt.DB, requester int64, findID int64, title string,
 		updated = true
 	}
 	if c.Selector != search {
+		if err := ValidateMatcher(check.Matcher, search); err != nil {
+			// Patterns like (?P<name>...) would read as HTML tags
+			return html.EscapeString(err.Error())
+		}
 		check.Selector = search
 		updated = true
 	}
@@ -464,20 +726,146 @@ func (c *Check) Modify(db *bolt.DB, requester int64, findID int64, title string,
 		return "no modifications given"
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		data, err := json.Marshal(check)
-		if err != nil {
-			return err
+	err = db.Update(check.put)
+
+	if err != nil {
+		// println(err.Error(), http.StatusBadRequest)
+		return err.Error()
+	}
+
+	if err = scheduler.Set(check); err != nil {
+		return err.Error()
+	}
+
+	return "Edited"
+}
+
+func (c *Check) Set(db *bolt.DB, requester int64, findID int64, field string, value string) (result string) {
+	check := &Check{}
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(UrlsBucket).Get(KeyFor(findID))
+		if data == nil {
+			return fmt.Errorf("no such check: %d", findID)
 		}
 
-		if err = tx.Bucket(UrlsBucket).Put(KeyFor(check.ID), data); err != nil {
+		if err := json.Unmarshal(data, check); err != nil {
+			println("error unmarshaling json", err)
 			return err
 		}
+
+		check.ID = uint64(findID)
 		return nil
 	})
 
 	if err != nil {
-		// println(err.Error(), http.StatusBadRequest)
+		println(err.Error(), http.StatusBadRequest)
+		return err.Error()
+	}
+
+	if requester != int64(check.UserID) {
+		return "Not your check"
+	}
+
+	switch field {
+	case "matcher":
+		if err := ValidateMatcher(value, check.Selector); err != nil {
+			// Patterns like (?P<name>...) would read as HTML tags
+			return html.EscapeString(err.Error())
+		}
+		check.Matcher = value
+	case "attribute":
+		check.Attribute = value
+	case "value":
+		if err := ValidateValue(check.Operator, value); err != nil {
+			return err.Error()
+		}
+		check.Value = value
+	case "operator":
+		if value != "" {
+			if err := ValidateOperator(value); err != nil {
+				return err.Error()
+			}
+		}
+		if err := ValidateValue(value, check.Value); err != nil {
+			return err.Error()
+		}
+		check.Operator = value
+	case "threshold":
+		if value != "" {
+			if err := ValidateThreshold(value); err != nil {
+				return err.Error()
+			}
+		}
+		check.Threshold = value
+		check.LastValue = nil
+	case "retention":
+		limit, days, err := ParseRetention(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.HistoryLimit = limit
+		check.HistoryDays = days
+	case "notifiers":
+		values := ParseNotifiers(value)
+		if err := ValidateNotifiers(check, values); err != nil {
+			return err.Error()
+		}
+		check.Notifiers = values
+	case "failures":
+		network, status, err := ParseFailureThresholds(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.NetworkThreshold = network
+		check.StatusThreshold = status
+	case "method":
+		method, err := ParseMethod(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.Method = method
+	case "headers":
+		headers, err := ParseHeaders(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.Headers = headers
+	case "body":
+		check.Body = value
+	case "contenttype":
+		contentType, err := ParseContentType(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.ContentType = contentType
+	case "login":
+		loginURL, credentials, err := ParseLogin(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.LoginURL = loginURL
+		check.LoginCredentials = credentials
+	case "maxsize":
+		size, err := ParseSize(value)
+		if err != nil {
+			return err.Error()
+		}
+		check.MaxBodySize = size
+	default:
+		return "unknown field " + field
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if field == "login" {
+			// Cookies of the old login are useless
+			if err := DeleteCookies(tx, check.ID); err != nil {
+				return err
+			}
+		}
+		return check.put(tx)
+	})
+
+	if err != nil {
 		return err.Error()
 	}
 
@@ -593,3 +981,57 @@ func (c *User) Check(db *bolt.DB, id uint64) (found bool) {
 
 	return (err == nil)
 }
+
+func (c *User) Get(db *bolt.DB, id uint64) (result *User) {
+	user := &User{}
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(UsersBucket).Get(KeyFor(id))
+		if data == nil {
+			return fmt.Errorf("User not found: %d", id)
+		}
+
+		return json.Unmarshal(data, user)
+	})
+
+	if err != nil {
+		println(err.Error(), http.StatusBadRequest)
+		return
+	}
+	return user
+}
+
+// SetEmail stores the address email alerts are sent to, an empty address
+// removes it.
+func (c *User) SetEmail(db *bolt.DB, id uint64, email string) (result string) {
+	if email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			return "wrong email: " + err.Error()
+		}
+		email = address.Address
+	}
+
+	user := c.Get(db, id)
+	if user == nil {
+		return "Not authorized"
+	}
+	user.Email = email
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(UsersBucket).Put(KeyFor(id), data)
+	})
+
+	if err != nil {
+		return err.Error()
+	}
+
+	if email == "" {
+		return "Email removed"
+	}
+	return "Email set to " + html.EscapeString(email)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var encryptionKey = flag.String("encryption-key", "", "passphrase for credentials stored in the database, defaults to $GOURLWATCHER_KEY")
+
+var ErrNoEncryptionKey = errors.New("credentials can't be stored, the bot runs without -encryption-key")
+
+// Key of the scrypt salt in SettingsBucket, made on the first run with a
+// passphrase.
+var encryptionSaltKey = []byte("encryption_salt")
+
+// Key derived from the passphrase by LoadEncryptionKey.
+var cipherKey []byte
+
+// LoadEncryptionKey derives the key from the passphrase of the flag or the
+// environment with scrypt, salted with the salt stored in the database.
+func LoadEncryptionKey(db *bolt.DB) error {
+	passphrase := *encryptionKey
+	if passphrase == "" {
+		passphrase = os.Getenv("GOURLWATCHER_KEY")
+	}
+	if passphrase == "" {
+		return nil
+	}
+
+	var salt []byte
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(SettingsBucket)
+		salt = append(salt, b.Get(encryptionSaltKey)...)
+		if len(salt) > 0 {
+			return nil
+		}
+
+		salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		return b.Put(encryptionSaltKey, salt)
+	})
+	if err != nil {
+		return err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return err
+	}
+	cipherKey = key
+	return nil
+}
+
+// newCipher is the AES-GCM cipher keyed with the key of the passphrase.
+func newCipher() (cipher.AEAD, error) {
+	if cipherKey == nil {
+		return nil, ErrNoEncryptionKey
+	}
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals data, returning the nonce and ciphertext as base64.
+func Encrypt(data []byte) (string, error) {
+	aead, err := newCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+// Decrypt opens a value sealed by Encrypt.
+func Decrypt(value string) ([]byte, error) {
+	aead, err := newCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	// Longest Telegram message, longer ones are cut by SplitSubN.
+	MaxMessageLength = 4000
+
+	// Longest diff added to a notification, so it still fits in a single
+	// message after SplitSubN.
+	MaxDiffLength = 3000
+
+	// Unchanged characters shown around each change.
+	diffContext = 40
+
+	// Texts longer than this are compared line by line.
+	diffLinesFrom = 20000
+)
+
+// DiffHTML renders the changes between two texts as <del> and <ins>
+// fragments with a little unchanged context around them, cut to max
+// characters.
+func DiffHTML(old string, new string, max int) string {
+	return renderDiff(old, new, max, func(t diffmatchpatch.Operation, text string) string {
+		text = html.EscapeString(text)
+		switch t {
+		case diffmatchpatch.DiffDelete:
+			return "<del>" + text + "</del>"
+		case diffmatchpatch.DiffInsert:
+			return "<ins>" + text + "</ins>"
+		}
+		return text
+	})
+}
+
+// DiffText renders the changes like DiffHTML, marking them as [-removed-]
+// and {+added+} for plain text channels.
+func DiffText(old string, new string, max int) string {
+	return renderDiff(old, new, max, func(t diffmatchpatch.Operation, text string) string {
+		switch t {
+		case diffmatchpatch.DiffDelete:
+			return "[-" + text + "-]"
+		case diffmatchpatch.DiffInsert:
+			return "{+" + text + "+}"
+		}
+		return text
+	})
+}
+
+func renderDiff(old string, new string, max int, format func(diffmatchpatch.Operation, string) string) string {
+	dmp := diffmatchpatch.New()
+
+	var diffs []diffmatchpatch.Diff
+	if len(old)+len(new) > diffLinesFrom {
+		a, b, lines := dmp.DiffLinesToChars(old, new)
+		diffs = dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+	} else {
+		diffs = dmp.DiffCleanupSemantic(dmp.DiffMain(old, new, false))
+	}
+
+	var b strings.Builder
+	length := 0
+	for i, diff := range diffs {
+		text := diff.Text
+		if diff.Type == diffmatchpatch.DiffEqual {
+			text = diffEqualContext(text, i > 0, i < len(diffs)-1)
+		}
+
+		// The budget counts the formatted text, escaping and tags included
+		formatted := format(diff.Type, text)
+		if length+utf8.RuneCountInString(formatted) <= max {
+			b.WriteString(formatted)
+			length += utf8.RuneCountInString(formatted)
+			continue
+		}
+
+		b.WriteString(formatPrefix(diff.Type, text, max-length-1, format))
+		b.WriteString("…")
+		break
+	}
+
+	return b.String()
+}
+
+// formatPrefix formats the longest start of text that still fits in max
+// characters once formatted. The text is cut before formatting, so the cut
+// never lands inside a tag or an escaped entity.
+func formatPrefix(t diffmatchpatch.Operation, text string, max int, format func(diffmatchpatch.Operation, string) string) string {
+	runes := []rune(text)
+
+	result := ""
+	low, high := 1, len(runes)
+	for low <= high {
+		middle := (low + high) / 2
+		formatted := format(t, string(runes[:middle]))
+		if utf8.RuneCountInString(formatted) <= max {
+			result = formatted
+			low = middle + 1
+		} else {
+			high = middle - 1
+		}
+	}
+	return result
+}
+
+// diffEqualContext shortens unchanged text to the context shown after the
+// previous change and before the next one.
+func diffEqualContext(text string, after bool, before bool) string {
+	runes := []rune(text)
+	if len(runes) <= 2*diffContext {
+		return text
+	}
+
+	result := ""
+	if after {
+		result += string(runes[:diffContext])
+	}
+	result += "…"
+	if before {
+		result += string(runes[len(runes)-diffContext:])
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var smtpAddr = flag.String("smtp", "", "SMTP server host:port for email alerts")
+var smtpUser = flag.String("smtp-user", "", "SMTP user")
+var smtpPassword = flag.String("smtp-password", "", "SMTP password")
+var smtpFrom = flag.String("smtp-from", "", "sender address of email alerts")
+
+// Longest diff put into an email.
+const MaxEmailDiffLength = 20000
+
+// Sends events by email to the address the check owner set with /email.
+type EmailNotifier struct {
+	DB       *bolt.DB
+	Addr     string
+	User     string
+	Password string
+	From     string
+}
+
+func (n *EmailNotifier) ValidateTarget(check *Check, target string) error {
+	user := &User{}
+	user = user.Get(n.DB, check.UserID)
+	if user == nil || user.Email == "" {
+		return fmt.Errorf("set your address with /email first")
+	}
+	return nil
+}
+
+func (n *EmailNotifier) Notify(event *Event, target string) error {
+	user := &User{}
+	user = user.Get(n.DB, event.Check.UserID)
+	if user == nil || user.Email == "" {
+		return fmt.Errorf("no email for user %d", event.Check.UserID)
+	}
+
+	message, err := n.message(event, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return n.send(user.Email, message)
+}
+
+func (n *EmailNotifier) message(event *Event, to string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	subject := fmt.Sprintf("%s %s", event.Check.Title, event.State())
+	if event.Check.Title == "" {
+		subject = fmt.Sprintf("%s %s", event.Check.URL, event.State())
+	}
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "From: %s\r\n", n.From)
+	fmt.Fprintf(&header, "To: %s\r\n", to)
+	fmt.Fprintf(&header, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&header, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&header, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&header, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct {
+		contentType string
+		text        string
+	}{
+		{"text/plain; charset=utf-8", event.PlainText(MaxEmailDiffLength)},
+		{"text/html; charset=utf-8", event.EmailHTML()},
+	}
+
+	for _, part := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.text)); err != nil {
+			return nil, err
+		}
+		qp.Close()
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return append(header.Bytes(), body.Bytes()...), nil
+}
+
+// send delivers the message, upgrading the connection with STARTTLS when
+// the server offers it.
+func (n *EmailNotifier) send(to string, message []byte) error {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.Dial(n.Addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if n.User != "" {
+		if err = client.Auth(smtp.PlainAuth("", n.User, n.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(n.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (e *Event) EmailHTML() string {
+	text := fmt.Sprintf("<p><b>%s</b> <i>%s</i><br><a href=\"%s\">%s</a></p>", html.EscapeString(e.Check.Title), e.State(), html.EscapeString(e.Check.URL), html.EscapeString(e.Check.URL))
+
+	if e.Error != "" {
+		text += fmt.Sprintf("<p>%s</p>", html.EscapeString(e.Error))
+	}
+
+	if e.Value != nil {
+		text += fmt.Sprintf("<p>value: %s", FormatNumber(*e.Value))
+		if e.LastValue != nil {
+			text += fmt.Sprintf(" (was %s)", FormatNumber(*e.LastValue))
+		}
+		text += "</p>"
+	}
+
+	for _, capture := range e.Captures {
+		text += fmt.Sprintf("<p>%s: %s</p>", html.EscapeString(capture.Name), html.EscapeString(capture.Value))
+	}
+
+	if e.OldText != "" {
+		text += "<pre style=\"white-space: pre-wrap\">" + DiffHTML(e.OldText, e.NewText, MaxEmailDiffLength) + "</pre>"
+	}
+	return text
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Consecutive failures before a check without its own threshold is
+// reported down.
+const DefaultFailureThreshold = 3
+
+// Kinds of fetch failures, counted separately.
+const (
+	FailureNetwork = "network"
+	FailureStatus  = "status"
+)
+
+// failureReason is the error of a fetch without the URL it was sent to,
+// which holds the values of the secrets it references.
+func failureReason(err error) string {
+	if e, ok := err.(*url.Error); ok {
+		return e.Err.Error()
+	}
+	return err.Error()
+}
+
+// fetchFailed counts a failed fetch and reports the check down once the
+// failures reach the threshold of their kind.
+func (c *Check) fetchFailed(db *bolt.DB, kind string, reason string) {
+	println("error fetching check", c.ID, c.URL, reason)
+
+	threshold := 0
+	failures := 0
+	if kind == FailureNetwork {
+		c.NetworkFailures++
+		c.StatusFailures = 0
+		failures, threshold = c.NetworkFailures, c.NetworkThreshold
+	} else {
+		c.StatusFailures++
+		c.NetworkFailures = 0
+		failures, threshold = c.StatusFailures, c.StatusThreshold
+	}
+	if threshold == 0 {
+		threshold = DefaultFailureThreshold
+	}
+
+	c.LastError = reason
+	c.LastErrorAt = time.Now()
+	c.LastChecked = c.LastErrorAt
+
+	if !c.IsDown && failures >= threshold {
+		c.IsDown = true
+
+		check := *c
+		Notify(&Event{
+			Check: &check,
+			Kind:  EventDown,
+			Error: reason,
+			Time:  c.LastErrorAt,
+		})
+	}
+
+	if err := c.save(db, nil); err != nil {
+		println("error saving check", c.ID, err.Error())
+	}
+}
+
+// fetchSucceeded resets the failure counters and reports a down check up
+// again.
+func (c *Check) fetchSucceeded() {
+	c.NetworkFailures = 0
+	c.StatusFailures = 0
+
+	if c.IsDown {
+		c.IsDown = false
+
+		check := *c
+		Notify(&Event{
+			Check: &check,
+			Kind:  EventUp,
+			Error: c.LastError,
+			Time:  time.Now(),
+		})
+	}
+}
+
+// ParseFailureThresholds reads the thresholds for network and HTTP status
+// failures like "3 5", a single number sets both. An empty value goes back
+// to the default.
+func ParseFailureThresholds(value string) (network int, status int, err error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, 0, nil
+	}
+	if len(fields) > 2 {
+		return 0, 0, fmt.Errorf("please send in format\nnetwork [status]")
+	}
+
+	if network, err = strconv.Atoi(fields[0]); err != nil || network <= 0 {
+		return 0, 0, fmt.Errorf("wrong number of failures: %s", fields[0])
+	}
+
+	status = network
+	if len(fields) == 2 {
+		if status, err = strconv.Atoi(fields[1]); err != nil || status <= 0 {
+			return 0, 0, fmt.Errorf("wrong number of failures: %s", fields[1])
+		}
+	}
+	return
+}
+
+func (c *Check) FailuresPretty() string {
+	network, status := c.NetworkThreshold, c.StatusThreshold
+	if network == 0 {
+		network = DefaultFailureThreshold
+	}
+	if status == 0 {
+		status = DefaultFailureThreshold
+	}
+
+	result := fmt.Sprintf("%d/%d network, %d/%d status", c.NetworkFailures, network, c.StatusFailures, status)
+	if c.IsDown {
+		result += " (down)"
+	}
+	return result
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+// Snapshots kept for a check without a retention policy.
+const DefaultHistoryLimit = 20
+
+// Helper struct for serialization.
+type Snapshot struct {
+	ID      uint64    `json:"id"`
+	CheckID uint64    `json:"check_id"`
+	Time    time.Time `json:"time"`
+	Hash    string    `json:"hash"`
+	Found   bool      `json:"found"`
+	Status  int       `json:"status"`
+	Body    string    `json:"body"`
+
+	// The snapshot date, as a string.
+	TimePretty string `json:"-"`
+
+	// The first 8 characters of the hash
+	ShortHash string `json:"-"`
+}
+
+func (s *Snapshot) PrepareForDisplay() {
+	s.TimePretty = s.Time.Format("Jan 2, 2006 at 3:04pm (MST)")
+
+	if len(s.Hash) > 8 {
+		s.ShortHash = s.Hash[0:8]
+	} else {
+		s.ShortHash = s.Hash
+	}
+}
+
+// Save stores the snapshot in the history of the check and drops the
+// snapshots the check retention policy no longer keeps.
+func (s *Snapshot) Save(tx *bolt.Tx, check *Check) error {
+	b, err := tx.Bucket(HistoryBucket).CreateBucketIfNotExists(KeyFor(check.ID))
+	if err != nil {
+		return err
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	s.ID = seq
+	s.CheckID = check.ID
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err = b.Put(snapshotKey(seq), data); err != nil {
+		return err
+	}
+
+	return pruneSnapshots(b, check)
+}
+
+// snapshotKey is big endian, unlike KeyFor, so bolt keeps the snapshots in
+// order.
+func snapshotKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// pruneSnapshots drops the oldest snapshots first, and stops at the first
+// one the retention keeps. Only their times are decoded.
+func pruneSnapshots(b *bolt.Bucket, check *Check) error {
+	limit := check.HistoryLimit
+	if limit == 0 && check.HistoryDays == 0 {
+		limit = DefaultHistoryLimit
+	}
+	oldest := time.Now().AddDate(0, 0, -check.HistoryDays)
+
+	count := 0
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		count++
+	}
+
+	// The latest snapshot holds the content the next change is compared
+	// with, so it is kept whatever the retention
+	expired := [][]byte{}
+	for k, v := c.First(); k != nil && count > 1; k, v = c.Next() {
+		if limit == 0 || count <= limit {
+			var snapshot struct {
+				Time time.Time `json:"time"`
+			}
+			if err := json.Unmarshal(v, &snapshot); err != nil {
+				return err
+			}
+			if check.HistoryDays == 0 || !snapshot.Time.Before(oldest) {
+				break
+			}
+		}
+
+		expired = append(expired, append([]byte{}, k...))
+		count--
+	}
+
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSnapshots returns the snapshots of a history bucket, newest first.
+func readSnapshots(b *bolt.Bucket) (snapshots []*Snapshot) {
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		snapshot := &Snapshot{}
+		if err := json.Unmarshal(v, snapshot); err != nil {
+			println("error unmarshaling json", err)
+			continue
+		}
+
+		snapshot.ID = binary.BigEndian.Uint64(k)
+		snapshot.PrepareForDisplay()
+
+		snapshots = append(snapshots, snapshot)
+	}
+	return
+}
+
+// latestSnapshot returns the newest snapshot of a history bucket, if any.
+func latestSnapshot(b *bolt.Bucket) *Snapshot {
+	k, v := b.Cursor().Last()
+	if k == nil {
+		return nil
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(v, snapshot); err != nil {
+		println("error unmarshaling json", err)
+		return nil
+	}
+	snapshot.ID = binary.BigEndian.Uint64(k)
+	return snapshot
+}
+
+// GetSnapshots loads the history of a check, newest first.
+func GetSnapshots(db *bolt.DB, checkID uint64, output *[]*Snapshot) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(HistoryBucket).Bucket(KeyFor(checkID))
+		if b == nil {
+			return nil
+		}
+
+		*output = append(*output, readSnapshots(b)...)
+		return nil
+	})
+}
+
+func DeleteSnapshots(tx *bolt.Tx, checkID uint64) error {
+	b := tx.Bucket(HistoryBucket)
+	if b.Bucket(KeyFor(checkID)) == nil {
+		return nil
+	}
+	return b.DeleteBucket(KeyFor(checkID))
+}
+
+func (c *Check) History(db *bolt.DB, requester int64, findID string) (result string) {
+	check := c.Get(db, findID)
+	if check == nil {
+		return "wrong id"
+	}
+
+	if requester != int64(check.UserID) {
+		return "Not your check"
+	}
+
+	var snapshots []*Snapshot
+	if err := GetSnapshots(db, check.ID, &snapshots); err != nil {
+		println("error loading history", err)
+		return err.Error()
+	}
+
+	for _, v := range snapshots {
+		state := "NOT found"
+		if v.Found {
+			state = "found"
+		}
+		result += fmt.Sprintf("\n#%d %s %s <i>%s</i> (%d)", v.ID, v.TimePretty, v.ShortHash, state, v.Status)
+	}
+
+	if result == "" {
+		return "Empty history"
+	}
+	return fmt.Sprintf("/%d <b>%s</b> history:%s", check.ID, html.EscapeString(check.Title), result)
+}
+
+// Diff renders the changes between two snapshots of the check, the two
+// newest ones when from and to are empty.
+func (c *Check) Diff(db *bolt.DB, requester int64, findID string, from string, to string) (result string) {
+	check := c.Get(db, findID)
+	if check == nil {
+		return "wrong id"
+	}
+
+	if requester != int64(check.UserID) {
+		return "Not your check"
+	}
+
+	var snapshots []*Snapshot
+	if err := GetSnapshots(db, check.ID, &snapshots); err != nil {
+		println("error loading history", err)
+		return err.Error()
+	}
+
+	if len(snapshots) < 2 && (from == "" || to == "") {
+		return "not enough history to compare"
+	}
+
+	var old, new *Snapshot
+	if from == "" {
+		old = snapshots[1]
+	} else if old = findSnapshot(snapshots, from); old == nil {
+		return "no such snapshot: " + from
+	}
+	if to == "" {
+		new = snapshots[0]
+	} else if new = findSnapshot(snapshots, to); new == nil {
+		return "no such snapshot: " + to
+	}
+
+	header := fmt.Sprintf("/%d <b>%s</b>\n#%d %s → #%d %s\n\n", check.ID, html.EscapeString(Short(check.Title, 200)), old.ID, old.TimePretty, new.ID, new.TimePretty)
+
+	// The reply must fit in one message, which SplitSubN cuts at 4000
+	max := MaxDiffLength
+	if rest := MaxMessageLength - utf8.RuneCountInString(header); rest < max {
+		max = rest
+	}
+
+	diff := DiffHTML(old.Body, new.Body, max)
+	if diff == "" {
+		diff = "no changes"
+	}
+
+	return header + diff
+}
+
+func findSnapshot(snapshots []*Snapshot, findID string) *Snapshot {
+	id, err := strconv.ParseUint(strings.TrimPrefix(findID, "#"), 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	for _, v := range snapshots {
+		if v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// ParseRetention reads a retention policy: a number of snapshots like "20"
+// or a number of days like "30d".
+func ParseRetention(retention string) (limit int, days int, err error) {
+	retention = strings.TrimSpace(retention)
+	if strings.HasSuffix(retention, "d") {
+		days, err = strconv.Atoi(strings.TrimSuffix(retention, "d"))
+		if err != nil || days <= 0 {
+			return 0, 0, fmt.Errorf("wrong number of days: %s", retention)
+		}
+		return
+	}
+
+	limit, err = strconv.Atoi(retention)
+	if err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("wrong number of snapshots: %s", retention)
+	}
+	return
+}
+
+func (c *Check) RetentionPretty() string {
+	if c.HistoryDays > 0 {
+		return fmt.Sprintf("keep %d days", c.HistoryDays)
+	}
+	if c.HistoryLimit > 0 {
+		return fmt.Sprintf("keep %d snapshots", c.HistoryLimit)
+	}
+	return fmt.Sprintf("keep %d snapshots", DefaultHistoryLimit)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// CookieJar keeps the cookies of a check between runs, stored encrypted in
+// CookiesBucket.
+type CookieJar struct {
+	sync.Mutex
+	list []*http.Cookie
+}
+
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Lock()
+	defer j.Unlock()
+
+	host := strings.ToLower(u.Hostname())
+	for _, cookie := range cookies {
+		stored := *cookie
+		if stored.Domain == "" {
+			stored.Domain = host
+		}
+		stored.Domain = strings.TrimPrefix(strings.ToLower(stored.Domain), ".")
+
+		// A host may only set cookies for itself or a parent domain, an IP
+		// address only for itself
+		if host != stored.Domain && (net.ParseIP(host) != nil || !strings.HasSuffix(host, "."+stored.Domain)) {
+			continue
+		}
+		if stored.Path == "" || !strings.HasPrefix(stored.Path, "/") {
+			stored.Path = "/"
+		}
+		if stored.MaxAge > 0 {
+			stored.Expires = time.Now().Add(time.Duration(stored.MaxAge) * time.Second)
+		}
+		stored.MaxAge = 0
+
+		expired := cookie.MaxAge < 0 || (!stored.Expires.IsZero() && stored.Expires.Before(time.Now()))
+
+		// Replace the cookie with the same name, domain and path
+		kept := []*http.Cookie{}
+		for _, c := range j.list {
+			if c.Name != stored.Name || c.Domain != stored.Domain || c.Path != stored.Path {
+				kept = append(kept, c)
+			}
+		}
+		j.list = kept
+
+		if !expired {
+			j.list = append(j.list, &stored)
+		}
+	}
+}
+
+func (j *CookieJar) Cookies(u *url.URL) (cookies []*http.Cookie) {
+	j.Lock()
+	defer j.Unlock()
+
+	host := strings.ToLower(u.Hostname())
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	for _, c := range j.list {
+		if host != c.Domain && !strings.HasSuffix(host, "."+c.Domain) {
+			continue
+		}
+		if !strings.HasPrefix(path, c.Path) {
+			continue
+		}
+		if c.Secure && u.Scheme != "https" {
+			continue
+		}
+		if !c.Expires.IsZero() && c.Expires.Before(time.Now()) {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return
+}
+
+func (j *CookieJar) Empty() bool {
+	j.Lock()
+	defer j.Unlock()
+
+	return len(j.list) == 0
+}
+
+// loadJar reads the cookies of the check, an unreadable jar starts empty.
+func (c *Check) loadJar(db *bolt.DB) *CookieJar {
+	jar := &CookieJar{}
+
+	var value []byte
+	db.View(func(tx *bolt.Tx) error {
+		value = append(value, tx.Bucket(CookiesBucket).Get(KeyFor(c.ID))...)
+		return nil
+	})
+	if len(value) == 0 {
+		return jar
+	}
+
+	data, err := Decrypt(string(value))
+	if err != nil {
+		println("error decrypting cookies", c.ID, err.Error())
+		return jar
+	}
+	if err = json.Unmarshal(data, &jar.list); err != nil {
+		println("error unmarshaling cookies", c.ID, err.Error())
+	}
+	return jar
+}
+
+func (c *Check) saveJar(db *bolt.DB, jar *CookieJar) error {
+	jar.Lock()
+	data, err := json.Marshal(jar.list)
+	jar.Unlock()
+	if err != nil {
+		return err
+	}
+
+	value, err := Encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(CookiesBucket).Put(KeyFor(c.ID), []byte(value))
+	})
+}
+
+func DeleteCookies(tx *bolt.Tx, id uint64) error {
+	return tx.Bucket(CookiesBucket).Delete(KeyFor(id))
+}
+
+// fetch requests the check. When it has a login, cookies are kept in its
+// jar and the login is posted first, and again once the page asks for it.
+func (c *Check) fetch(db *bolt.DB, client *http.Client) (*http.Response, error) {
+	if c.LoginURL == "" {
+		req, err := c.NewRequest(db)
+		if err != nil {
+			return nil, err
+		}
+		return client.Do(req)
+	}
+
+	jar := c.loadJar(db)
+	client.Jar = jar
+
+	if jar.Empty() {
+		if err := c.login(client); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := c.NewRequest(db)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.loginRequired(resp) {
+		resp.Body.Close()
+
+		if err = c.login(client); err != nil {
+			return nil, err
+		}
+
+		if req, err = c.NewRequest(db); err != nil {
+			return nil, err
+		}
+		if resp, err = client.Do(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = c.saveJar(db, jar); err != nil {
+		println("error saving cookies", c.ID, err.Error())
+	}
+	return resp, nil
+}
+
+// login posts the credentials of the check to its login URL.
+func (c *Check) login(client *http.Client) error {
+	credentials, err := Decrypt(c.LoginCredentials)
+	if err != nil {
+		return fmt.Errorf("login failed: %s", err.Error())
+	}
+
+	req, err := http.NewRequest("POST", c.LoginURL, strings.NewReader(string(credentials)))
+	if err != nil {
+		return fmt.Errorf("login failed: %s", failureReason(err))
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("login failed: %s", failureReason(err))
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login failed: HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// loginRequired tells if the page refused the cookies, or redirected to the
+// login page.
+func (c *Check) loginRequired(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return true
+	}
+
+	login, err := url.Parse(c.LoginURL)
+	if err != nil || resp.Request == nil {
+		return false
+	}
+
+	page := resp.Request.URL
+	return page.Host == login.Host && page.Path == login.Path && c.URL != resp.Request.URL.String()
+}
+
+// ParseLogin reads the login URL from the first line and the form fields
+// to post from the rest, like
+// https://example.com/login
+// user=me&password=secret
+func ParseLogin(value string) (loginURL string, credentials string, err error) {
+	lines := strings.SplitN(strings.TrimSpace(value), "\n", 2)
+	if lines[0] == "" {
+		return "", "", nil
+	}
+
+	if len(lines) != 2 || strings.TrimSpace(lines[1]) == "" {
+		return "", "", fmt.Errorf("please send in format\nlogin_url\nuser=name&password=secret")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(lines[0]))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", fmt.Errorf("wrong login url: %s", lines[0])
+	}
+
+	fields := strings.TrimSpace(lines[1])
+	if _, err = url.ParseQuery(fields); err != nil {
+		return "", "", fmt.Errorf("wrong login fields: %s", err.Error())
+	}
+
+	if credentials, err = Encrypt([]byte(fields)); err != nil {
+		return "", "", err
+	}
+	return u.String(), credentials, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"html"
 	"log"
 	"os"
 	"strconv"
@@ -12,7 +13,6 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/robfig/cron"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
@@ -23,14 +23,50 @@ type telegramResponse struct {
 }
 
 var (
-	UrlsBucket  = []byte("urls")
-	UsersBucket = []byte("users")
+	UrlsBucket    = []byte("urls")
+	UsersBucket   = []byte("users")
+	HistoryBucket = []byte("history")
+
+	WebhooksBucket   = []byte("webhooks")
+	DeliveriesBucket = []byte("deliveries")
+	CookiesBucket    = []byte("cookies")
+	SecretsBucket    = []byte("secrets")
+	SettingsBucket   = []byte("settings")
 
 	telegramChan chan telegramResponse
 	innerChan    chan telegramResponse
 	outerChan    chan telegramResponse
 
 	commandKeyboard tgbotapi.ReplyKeyboardMarkup
+
+	// Commands that change a single field of a check, see Check.Set
+	fieldCommands = map[string]string{
+		"/updatematcher":     "matcher",
+		"/updateattribute":   "attribute",
+		"/updatevalue":       "value",
+		"/updateoperator":    "operator",
+		"/updatethreshold":   "threshold",
+		"/updateretention":   "retention",
+		"/updatenotifiers":   "notifiers",
+		"/updatefailures":    "failures",
+		"/updatemethod":      "method",
+		"/updateheaders":     "headers",
+		"/updatebody":        "body",
+		"/updatecontenttype": "contenttype",
+		"/updatelogin":       "login",
+		"/updatemaxsize":     "maxsize",
+	}
+
+	// Commands whose arguments may hold credentials, with the number of
+	// arguments that are safe to log
+	secretCommands = map[string]int{
+		"/addsecret":       1,
+		"/addwebhook":      1,
+		"/updatelogin":     1,
+		"/updatenotifiers": 1,
+		"/updateheaders":   1,
+		"/updatebody":      1,
+	}
 )
 
 var telegramToken = flag.String("token", "", "token")
@@ -47,7 +83,7 @@ func main() {
 	defer db.Close()
 
 	// Create collections.
-	buckets := [][]byte{UrlsBucket, UsersBucket}
+	buckets := [][]byte{UrlsBucket, UsersBucket, HistoryBucket, WebhooksBucket, DeliveriesBucket, CookiesBucket, SecretsBucket, SettingsBucket}
 	db.Update(func(tx *bolt.Tx) error {
 		for _, v := range buckets {
 			b := tx.Bucket(v)
@@ -58,9 +94,14 @@ func main() {
 		return nil
 	})
 
-	c := cron.New()
-	c.Start()
-	defer c.Stop()
+	if err = LoadEncryptionKey(db); err != nil {
+		println("error loading encryption key", err.Error())
+		return
+	}
+
+	pool := NewPool(*poolWorkers, *poolHostWorkers, *poolHostSpacing)
+	scheduler := NewScheduler(db, pool)
+	defer scheduler.Stop()
 
 	bot, err := tgbotapi.NewBotAPI(*telegramToken)
 	if err != nil {
@@ -73,11 +114,42 @@ func main() {
 	var ucfg = tgbotapi.NewUpdate(0)
 	ucfg.Timeout = 60
 
-	startChan, oc, ic, _ := commandsManager(db, c, bot)
+	startChan, oc, ic, _ := commandsManager(db, scheduler, bot)
 	innerChan = ic
 	outerChan = oc
 	telegramChan = make(chan telegramResponse)
 
+	RegisterNotifier("telegram", &TelegramNotifier{})
+	RegisterNotifier("webhook", &WebhookNotifier{DB: db})
+	RegisterNotifier("slack", &SlackNotifier{DB: db})
+	RegisterNotifier("discord", &DiscordNotifier{DB: db})
+	RegisterNotifier("mattermost", &MattermostNotifier{DB: db})
+
+	ntfyPriorities, err := ParsePriorities(*ntfyPriority)
+	if err != nil {
+		log.Fatalf("[INIT] [Failed to parse ntfy priorities: %v]", err)
+	}
+	RegisterNotifier("ntfy", &NtfyNotifier{DB: db, Token: *ntfyToken, Priorities: ntfyPriorities})
+
+	gotifyPriorities, err := ParsePriorities(*gotifyPriority)
+	if err != nil {
+		log.Fatalf("[INIT] [Failed to parse gotify priorities: %v]", err)
+	}
+	RegisterNotifier("gotify", &GotifyNotifier{DB: db, Priorities: gotifyPriorities})
+
+	if *matrixHomeserver != "" {
+		RegisterNotifier("matrix", &MatrixNotifier{Homeserver: *matrixHomeserver, Token: *matrixToken})
+	}
+	if *smtpAddr != "" {
+		RegisterNotifier("email", &EmailNotifier{
+			DB:       db,
+			Addr:     *smtpAddr,
+			User:     *smtpUser,
+			Password: *smtpPassword,
+			From:     *smtpFrom,
+		})
+	}
+
 	updates, err := bot.GetUpdatesChan(ucfg)
 
 	if err != nil {
@@ -104,11 +176,10 @@ func main() {
 
 	for _, v := range items {
 		if v.IsEnabled {
-			go v.Update(db)
-			id := v.ID
-			c.AddFunc(v.Schedule, func() {
-				TryUpdate(db, id)
-			})
+			go pool.Update(db, v)
+			if err = scheduler.Set(v); err != nil {
+				println("error scheduling check", v.ID, err.Error())
+			}
 		}
 	}
 
@@ -176,17 +247,27 @@ func main() {
 							}
 						}()
 					}
-				case "add":
+				case "email":
+					go func() {
+						telegramChan <- telegramResponse{user.SetEmail(db, uint64(userID), strings.TrimSpace(args)), chatID, -1}
+					}()
+				case "add", "addwebhook", "deletewebhook", "webhooks", "addsecret", "deletesecret", "secrets", "status":
 					if user.Check(db, uint64(userID)) {
 						// println("trying to add new check")
 						innerChan <- telegramResponse{text, chatID, -1}
+						if command == "addwebhook" || command == "addsecret" {
+							deleteMessage(bot, update.Message)
+						}
 					} else {
 						telegramChan <- telegramResponse{"Not authorized", chatID, -1}
 					}
-				case "info", "shot", "edit", "delete", "togglecontains", "toggleenabled", "updatesearch", "updateurl", "updatetitle", "togglerecovered":
+				case "info", "shot", "edit", "delete", "togglecontains", "toggleenabled", "updatesearch", "updateurl", "updatetitle", "togglerecovered", "updatematcher", "updateattribute", "updatevalue", "updateoperator", "updatethreshold", "updateretention", "updatenotifiers", "updatefailures", "updatemethod", "updateheaders", "updatebody", "updatecontenttype", "updatelogin", "updatemaxsize", "history", "diff", "template", "schedule":
 					// if user.Check(db, uint64(userID)) {
 					// println("toggle enabled")
 					innerChan <- telegramResponse{text, chatID, -1}
+					if command == "updatelogin" {
+						deleteMessage(bot, update.Message)
+					}
 					// } else {
 					// 	telegramChan <- telegramResponse{"Not authorized", chatID}
 					// }
@@ -202,7 +283,7 @@ func main() {
 
 							result := ""
 							for _, v := range my_items {
-								result += fmt.Sprintf("\n\n/%d <b>%s</b> (%s) %s", v.ID, v.Title, v.IsEnabledPretty, v.ShortURL)
+								result += fmt.Sprintf("\n\n/%d <b>%s</b> (%s) %s", v.ID, html.EscapeString(v.Title), v.IsEnabledPretty, html.EscapeString(v.ShortURL))
 							}
 							if result == "" {
 								result = "Empty list"
@@ -228,11 +309,13 @@ func main() {
 			resp.body = strings.Replace(string(resp.body), "</span>", "", -1)
 			resp.body = strings.Replace(string(resp.body), "<del ", "<i ", -1)
 			resp.body = strings.Replace(string(resp.body), "</del>", "</i>", -1)
+			resp.body = strings.Replace(string(resp.body), "<del>", "<i>", -1)
 			resp.body = strings.Replace(string(resp.body), "<ins ", "<b ", -1)
 			resp.body = strings.Replace(string(resp.body), "</ins>", "</b>", -1)
+			resp.body = strings.Replace(string(resp.body), "<ins>", "<b>", -1)
 			resp.body = strings.Replace(string(resp.body), "<br>", "\n", -1)
 
-			messages := SplitSubN(resp.body, 4000)
+			messages := SplitSubN(resp.body, MaxMessageLength)
 			for _, message := range messages {
 				log.Println(resp.to, message)
 
@@ -259,7 +342,7 @@ func main() {
 	// println("Finished")
 }
 
-func TryUpdate(db *bolt.DB, id uint64) {
+func TryUpdate(db *bolt.DB, pool *Pool, id uint64) {
 	// The task may have been deleted from the DB, so we try to fetch it first
 	check := &Check{}
 	found := false
@@ -291,10 +374,36 @@ func TryUpdate(db *bolt.DB, id uint64) {
 
 	check.PrepareForDisplay()
 	// println("Got a check.  Trigger an update.", check.ID, check.UserID)
-	go check.Update(db)
+	go pool.Update(db, check)
 }
 
-func commandsManager(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI) (startChan chan bool, outerChan, innerChan chan telegramResponse, stopChan chan int64) {
+// redactCommand hides the arguments of commands with credentials, for logs.
+func redactCommand(body string) string {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return body
+	}
+
+	kept, ok := secretCommands[strings.SplitN(fields[0], "@", 2)[0]]
+	if !ok || len(fields) <= kept+1 {
+		return body
+	}
+	return strings.Join(append(fields[:kept+1], "****"), " ")
+}
+
+// deleteMessage removes a message with credentials from the chat, so they
+// don't stay in its history.
+func deleteMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message == nil {
+		return
+	}
+
+	if _, err := bot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)); err != nil {
+		println("error deleting message", err.Error())
+	}
+}
+
+func commandsManager(db *bolt.DB, scheduler *Scheduler, bot *tgbotapi.BotAPI) (startChan chan bool, outerChan, innerChan chan telegramResponse, stopChan chan int64) {
 	startChan = make(chan bool)
 	outerChan = make(chan telegramResponse)
 	innerChan = make(chan telegramResponse)
@@ -303,9 +412,9 @@ func commandsManager(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI) (startC
 		for {
 			select {
 			case <-startChan:
-				go doCommand(db, cron, bot, innerChan, stopChan)
+				go doCommand(db, scheduler, bot, innerChan, stopChan)
 			case msg := <-outerChan:
-				fmt.Println("command <- ", msg)
+				fmt.Println("command <- ", redactCommand(msg.body))
 				//default:
 				//	time.Sleep(100 * time.Millisecond)
 			}
@@ -314,20 +423,73 @@ func commandsManager(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI) (startC
 	return startChan, outerChan, innerChan, stopChan
 }
 
-func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan chan telegramResponse, stopChan chan int64) {
+func doCommand(db *bolt.DB, scheduler *Scheduler, bot *tgbotapi.BotAPI, innerChan chan telegramResponse, stopChan chan int64) {
 	for {
 		select {
 		case msg := <-innerChan:
-			fmt.Println("command <- ", msg.body)
+			fmt.Println("command <- ", redactCommand(msg.body))
 			go func() {
-				if strings.HasPrefix(msg.body, "/delete") {
+				if strings.HasPrefix(msg.body, "/deletewebhook") {
+					stringSlice := strings.Fields(msg.body)
+					if len(stringSlice) >= 2 {
+						webhook := Webhook{}
+
+						if webhook.Delete(db, msg.to, stringSlice[1]) {
+							telegramChan <- telegramResponse{"Deleted", msg.to, -1}
+						} else {
+							telegramChan <- telegramResponse{"Not deleted", msg.to, -1}
+						}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/deletewebhook name", msg.to, -1}
+					}
+				} else if strings.HasPrefix(msg.body, "/addwebhook") {
+					stringSlice := strings.Fields(msg.body)
+					if len(stringSlice) >= 3 {
+						secret := ""
+						if len(stringSlice) >= 4 {
+							secret = stringSlice[3]
+						}
+
+						webhook := Webhook{}
+						telegramChan <- telegramResponse{webhook.New(db, msg.to, stringSlice[1], stringSlice[2], secret), msg.to, -1}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/addwebhook name url [secret]", msg.to, -1}
+					}
+				} else if strings.HasPrefix(msg.body, "/webhooks") {
+					telegramChan <- telegramResponse{WebhooksList(db, msg.to), msg.to, -1}
+				} else if strings.HasPrefix(msg.body, "/deletesecret") {
+					stringSlice := strings.Fields(msg.body)
+					if len(stringSlice) >= 2 {
+						secret := Secret{}
+
+						if secret.Delete(db, msg.to, stringSlice[1]) {
+							telegramChan <- telegramResponse{"Deleted", msg.to, -1}
+						} else {
+							telegramChan <- telegramResponse{"Not deleted", msg.to, -1}
+						}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/deletesecret name", msg.to, -1}
+					}
+				} else if strings.HasPrefix(msg.body, "/addsecret") {
+					stringSlice := strings.Fields(msg.body)
+					if len(stringSlice) >= 3 {
+						secret := Secret{}
+						telegramChan <- telegramResponse{secret.New(db, msg.to, stringSlice[1], strings.Join(stringSlice[2:], " ")), msg.to, -1}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/addsecret name value", msg.to, -1}
+					}
+				} else if strings.HasPrefix(msg.body, "/secrets") {
+					telegramChan <- telegramResponse{SecretsList(db, msg.to), msg.to, -1}
+				} else if strings.HasPrefix(msg.body, "/status") {
+					telegramChan <- telegramResponse{scheduler.pool.Status(), msg.to, -1}
+				} else if strings.HasPrefix(msg.body, "/delete") {
 					stringSlice := strings.Split(msg.body, " ")
 					if len(stringSlice) >= 2 {
 						if _, err := strconv.ParseInt(stringSlice[1], 10, 64); err == nil {
 							// fmt.Printf("%q looks like a number.\n", v)
 							check := Check{}
 
-							if check.Delete(db, msg.to, stringSlice[1]) {
+							if check.Delete(db, scheduler, msg.to, stringSlice[1]) {
 								telegramChan <- telegramResponse{"Deleted", msg.to, -1}
 							} else {
 								telegramChan <- telegramResponse{"Not deleted", msg.to, msg.check_id}
@@ -379,7 +541,7 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 							check := Check{}
 
 							check = *check.Get(db, stringSlice[1])
-							telegramChan <- telegramResponse{check.Modify(db, msg.to, int64(check.ID), check.Title, check.URL, check.Selector, !check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
+							telegramChan <- telegramResponse{check.Modify(db, scheduler, msg.to, int64(check.ID), check.Title, check.URL, check.Selector, !check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
 						}
 					}
 				} else if strings.HasPrefix(msg.body, "/toggleenabled") {
@@ -389,7 +551,7 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 							// fmt.Printf("%q looks like a number.\n", v)
 							check := Check{}
 							check = *check.Get(db, stringSlice[1])
-							telegramChan <- telegramResponse{check.Modify(db, msg.to, int64(check.ID), check.Title, check.URL, check.Selector, check.AlertIfPresent, !check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
+							telegramChan <- telegramResponse{check.Modify(db, scheduler, msg.to, int64(check.ID), check.Title, check.URL, check.Selector, check.AlertIfPresent, !check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
 						}
 					}
 				} else if strings.HasPrefix(msg.body, "/togglerecovered") {
@@ -399,7 +561,7 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 							// fmt.Printf("%q looks like a number.\n", v)
 							check := Check{}
 							check = *check.Get(db, stringSlice[1])
-							telegramChan <- telegramResponse{check.Modify(db, msg.to, int64(check.ID), check.Title, check.URL, check.Selector, check.AlertIfPresent, check.IsEnabled, !check.AlertOnlyRecovered), msg.to, msg.check_id}
+							telegramChan <- telegramResponse{check.Modify(db, scheduler, msg.to, int64(check.ID), check.Title, check.URL, check.Selector, check.AlertIfPresent, check.IsEnabled, !check.AlertOnlyRecovered), msg.to, msg.check_id}
 						}
 					}
 				} else if strings.HasPrefix(msg.body, "/updatesearch") {
@@ -412,10 +574,24 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 						check := Check{}
 
 						check = *check.Get(db, id)
-						telegramChan <- telegramResponse{check.Modify(db, msg.to, int64(check.ID), check.Title, check.URL, body, check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
+						telegramChan <- telegramResponse{check.Modify(db, scheduler, msg.to, int64(check.ID), check.Title, check.URL, body, check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
 					} else {
 						telegramChan <- telegramResponse{"please send in format\n/updatesearch id\n\ntext", msg.to, msg.check_id}
 					}
+				} else if field, ok := fieldCommands[strings.Fields(msg.body)[0]]; ok {
+					stringSlice := strings.Split(msg.body, "\n\n")
+					commandID := strings.Fields(stringSlice[0])
+					if len(commandID) >= 2 {
+						if id, err := strconv.ParseInt(commandID[1], 10, 64); err == nil {
+							// Omitted value clears the field
+							value := strings.Join(stringSlice[1:], "\n\n")
+
+							check := Check{}
+							telegramChan <- telegramResponse{check.Set(db, msg.to, id, field, value), msg.to, msg.check_id}
+						}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n" + commandID[0] + " id\n\nvalue", msg.to, msg.check_id}
+					}
 				} else if strings.HasPrefix(msg.body, "/updatetitle") {
 					stringSlice := strings.Split(msg.body, "\n\n")
 					if len(stringSlice) >= 2 {
@@ -426,7 +602,7 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 						check := Check{}
 
 						check = *check.Get(db, id)
-						telegramChan <- telegramResponse{check.Modify(db, msg.to, int64(check.ID), body, check.URL, check.Selector, check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
+						telegramChan <- telegramResponse{check.Modify(db, scheduler, msg.to, int64(check.ID), body, check.URL, check.Selector, check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
 					} else {
 						telegramChan <- telegramResponse{"please send in format\n/updatetitle id\n\ntitle", msg.to, msg.check_id}
 					}
@@ -440,10 +616,66 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 						check := Check{}
 
 						check = *check.Get(db, id)
-						telegramChan <- telegramResponse{check.Modify(db, msg.to, int64(check.ID), check.Title, body, check.Selector, check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
+						telegramChan <- telegramResponse{check.Modify(db, scheduler, msg.to, int64(check.ID), check.Title, body, check.Selector, check.AlertIfPresent, check.IsEnabled, check.AlertOnlyRecovered), msg.to, msg.check_id}
 					} else {
 						telegramChan <- telegramResponse{"please send in format\n/updateurl id\n\nurl", msg.to, msg.check_id}
 					}
+				} else if strings.HasPrefix(msg.body, "/template") {
+					stringSlice := strings.SplitN(msg.body, "\n\n", 2)
+					commandID := strings.Fields(stringSlice[0])
+
+					text := ""
+					if len(stringSlice) == 2 {
+						text = stringSlice[1]
+					}
+
+					if len(commandID) >= 2 {
+						check := Check{}
+						telegramChan <- telegramResponse{check.SetTemplate(db, msg.to, commandID[1], text), msg.to, msg.check_id}
+					} else {
+						user := User{}
+						telegramChan <- telegramResponse{user.SetTemplate(db, uint64(msg.to), text), msg.to, -1}
+					}
+				} else if strings.HasPrefix(msg.body, "/schedule") {
+					stringSlice := strings.Fields(msg.body)
+					if len(stringSlice) >= 2 {
+						spec := strings.Join(stringSlice[2:], " ")
+
+						check := Check{}
+						telegramChan <- telegramResponse{check.SetSchedule(db, scheduler, msg.to, stringSlice[1], spec), msg.to, msg.check_id}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/schedule id [spec]", msg.to, msg.check_id}
+					}
+				} else if strings.HasPrefix(msg.body, "/history") {
+					stringSlice := strings.Split(msg.body, " ")
+					if len(stringSlice) >= 2 {
+						if _, err := strconv.ParseInt(stringSlice[1], 10, 64); err == nil {
+							check := Check{}
+
+							telegramChan <- telegramResponse{check.History(db, msg.to, stringSlice[1]), msg.to, msg.check_id}
+						}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/history id", msg.to, msg.check_id}
+					}
+				} else if strings.HasPrefix(msg.body, "/diff") {
+					stringSlice := strings.Fields(msg.body)
+					if len(stringSlice) >= 2 {
+						if _, err := strconv.ParseInt(stringSlice[1], 10, 64); err == nil {
+							from, to := "", ""
+							if len(stringSlice) >= 3 {
+								from = stringSlice[2]
+							}
+							if len(stringSlice) >= 4 {
+								to = stringSlice[3]
+							}
+
+							check := Check{}
+
+							telegramChan <- telegramResponse{check.Diff(db, msg.to, stringSlice[1], from, to), msg.to, msg.check_id}
+						}
+					} else {
+						telegramChan <- telegramResponse{"please send in format\n/diff id [from] [to]", msg.to, msg.check_id}
+					}
 				} else if strings.HasPrefix(msg.body, "/info") {
 					stringSlice := strings.Split(msg.body, " ")
 					if len(stringSlice) >= 2 {
@@ -451,7 +683,7 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 							// fmt.Printf("%q looks like a number.\n", v)
 							check := Check{}
 
-							telegramChan <- telegramResponse{check.Info(db, msg.to, stringSlice[1]), msg.to, msg.check_id}
+							telegramChan <- telegramResponse{check.Info(db, scheduler, msg.to, stringSlice[1]), msg.to, msg.check_id}
 						}
 					}
 				} else if strings.HasPrefix(msg.body, "/add") {
@@ -462,13 +694,18 @@ func doCommand(db *bolt.DB, cron *cron.Cron, bot *tgbotapi.BotAPI, innerChan cha
 							url := commandURL[1]
 							body := strings.Join(stringSlice[1:], "\n\n")
 
+							matcher := ""
+							if len(commandURL) >= 3 {
+								matcher = commandURL[2]
+							}
+
 							check := Check{
-								Schedule: "0 * * * * *",
+								Schedule: DefaultSchedule,
 							}
 
-							telegramChan <- telegramResponse{check.New(db, cron, url, body, "true", msg.to), msg.to, msg.check_id}
+							telegramChan <- telegramResponse{check.New(db, scheduler, url, matcher, body, "true", msg.to), msg.to, msg.check_id}
 						} else {
-							telegramChan <- telegramResponse{"please send in format\n/add url\n\ntext", msg.to, msg.check_id}
+							telegramChan <- telegramResponse{"please send in format\n/add url [matcher]\n\ntext", msg.to, msg.check_id}
 						}
 					}()
 				}
@@ -512,6 +749,7 @@ func initKeyboard(db *bolt.DB, check *Check) (commandKeyboard tgbotapi.InlineKey
 	check_id := check.ID
 
 	info := fmt.Sprintf("/%s %d", "info", check_id)
+	history := fmt.Sprintf("/%s %d", "history", check_id)
 	delete := fmt.Sprintf("/%s %d", "delete", check_id)
 
 	togglecontains := fmt.Sprintf("/%s %d", "togglecontains", check_id)
@@ -539,6 +777,10 @@ func initKeyboard(db *bolt.DB, check *Check) (commandKeyboard tgbotapi.InlineKey
 				Text:         "Info",
 				CallbackData: &info,
 			},
+			tgbotapi.InlineKeyboardButton{
+				Text:         "History",
+				CallbackData: &history,
+			},
 			tgbotapi.InlineKeyboardButton{
 				Text:         "Delete",
 				CallbackData: &delete,
@@ -559,6 +801,17 @@ func initKeyboard(db *bolt.DB, check *Check) (commandKeyboard tgbotapi.InlineKey
 			},
 		),
 	)
+
+	schedules := tgbotapi.NewInlineKeyboardRow()
+	for _, preset := range schedulePresets {
+		data := fmt.Sprintf("/%s %d %s", "schedule", check_id, preset.Spec)
+		schedules = append(schedules, tgbotapi.InlineKeyboardButton{
+			Text:         preset.Title,
+			CallbackData: &data,
+		})
+	}
+	commandKeyboard.InlineKeyboard = append(commandKeyboard.InlineKeyboard, schedules)
+
 	// commandKeyboard = tgbotapi.NewReplyKeyboard(
 	// 	tgbotapi.NewKeyboardButtonRow(
 	// 		tgbotapi.NewKeyboardButton("/stats"),
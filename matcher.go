@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/jmespath/go-jmespath"
+	"golang.org/x/net/html"
+)
+
+// Matchers understood by Check.Match. Checks stored without a matcher are
+// treated as MatcherContains.
+const (
+	MatcherContains = "contains"
+	MatcherCSS      = "css"
+	MatcherXPath    = "xpath"
+	MatcherXML      = "xml"
+	MatcherJSON     = "json"
+	MatcherRegex    = "regex"
+)
+
+// Operators comparing a value extracted by MatcherJSON with Check.Value.
+// Checks stored without an operator use OperatorExists.
+var Operators = []string{"eq", "ne", "exists", "empty", "gt", "lt"}
+
+const OperatorExists = "exists"
+
+// Result of evaluating a check against a fetched page.
+type MatchResult struct {
+	// Whether the check found what it is looking for.
+	Found bool
+
+	// The part of the page the check is watching, used for hashing.
+	Text string
+
+	// Named groups captured by MatcherRegex, in pattern order.
+	Captures []Capture
+}
+
+type Capture struct {
+	Name  string
+	Value string
+}
+
+func (c *Check) Match(body string) (result MatchResult, err error) {
+	switch c.Matcher {
+	case "", MatcherContains:
+		result.Found = strings.Contains(body, c.Selector)
+		result.Text = body
+	case MatcherCSS:
+		return matchCSS(body, c.Selector, c.Attribute, c.Value)
+	case MatcherXPath:
+		return matchXPath(body, c.Selector, c.Attribute, c.Value)
+	case MatcherXML:
+		return matchXML(body, c.Selector, c.Attribute, c.Value)
+	case MatcherJSON:
+		return matchJSON(body, c.Selector, c.Operator, c.Value)
+	case MatcherRegex:
+		return matchRegex(body, c.Selector)
+	default:
+		err = fmt.Errorf("unknown matcher: %s", c.Matcher)
+	}
+	return
+}
+
+func ValidateMatcher(matcher string, selector string) error {
+	switch matcher {
+	case "", MatcherContains:
+		return nil
+	case MatcherCSS:
+		if _, err := cascadia.Compile(selector); err != nil {
+			return fmt.Errorf("wrong css selector: %s", err.Error())
+		}
+		return nil
+	case MatcherXPath, MatcherXML:
+		if _, err := xpath.Compile(selector); err != nil {
+			return fmt.Errorf("wrong xpath expression: %s", err.Error())
+		}
+		return nil
+	case MatcherJSON:
+		if _, err := jmespath.Compile(selector); err != nil {
+			return fmt.Errorf("wrong json path: %s", err.Error())
+		}
+		return nil
+	case MatcherRegex:
+		if _, err := regexp.Compile(selector); err != nil {
+			return fmt.Errorf("wrong regular expression: %s", err.Error())
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown matcher: %s", matcher)
+}
+
+func matchCSS(body string, selector string, attribute string, value string) (result MatchResult, err error) {
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		return
+	}
+
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	texts := []string{}
+	for _, node := range sel.MatchAll(doc) {
+		if text, ok := nodeValue(node, attribute); ok {
+			texts = append(texts, text)
+		}
+	}
+
+	return matchTexts(texts, value), nil
+}
+
+func matchXPath(body string, expr string, attribute string, value string) (result MatchResult, err error) {
+	doc, err := htmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	nodes, err := htmlquery.QueryAll(doc, expr)
+	if err != nil {
+		return
+	}
+
+	texts := []string{}
+	for _, node := range nodes {
+		if text, ok := nodeValue(node, attribute); ok {
+			texts = append(texts, text)
+		}
+	}
+
+	return matchTexts(texts, value), nil
+}
+
+func matchXML(body string, expr string, attribute string, value string) (result MatchResult, err error) {
+	doc, err := xmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	nodes, err := xmlquery.QueryAll(doc, expr)
+	if err != nil {
+		return
+	}
+
+	texts := []string{}
+	for _, node := range nodes {
+		if attribute == "" {
+			texts = append(texts, strings.Join(strings.Fields(node.InnerText()), " "))
+		} else if text := node.SelectAttr(attribute); text != "" {
+			texts = append(texts, text)
+		}
+	}
+
+	return matchTexts(texts, value), nil
+}
+
+func ValidateOperator(operator string) error {
+	for _, v := range Operators {
+		if v == operator {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown operator: %s, use one of %s", operator, strings.Join(Operators, ", "))
+}
+
+// ValidateValue checks that the value can be compared with the operator,
+// gt and lt only compare numbers.
+func ValidateValue(operator string, value string) error {
+	if operator != "gt" && operator != "lt" {
+		return nil
+	}
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return fmt.Errorf("value is not a number: %s, %s compares numbers", value, operator)
+	}
+	return nil
+}
+
+func matchJSON(body string, path string, operator string, value string) (result MatchResult, err error) {
+	var data interface{}
+	if err = json.Unmarshal([]byte(body), &data); err != nil {
+		return
+	}
+
+	found, err := jmespath.Search(path, data)
+	if err != nil {
+		return
+	}
+
+	// Marshaling sorts object keys, so reordered or reformatted responses
+	// hash the same
+	text, err := json.Marshal(found)
+	if err != nil {
+		return
+	}
+	result.Text = string(text)
+
+	if operator == "" {
+		operator = OperatorExists
+	}
+
+	switch operator {
+	case "exists":
+		result.Found = found != nil
+	case "empty":
+		switch v := found.(type) {
+		case []interface{}:
+			result.Found = len(v) == 0
+		case map[string]interface{}:
+			result.Found = len(v) == 0
+		}
+	case "eq", "ne":
+		// Value is compared as JSON when it parses, so 5, true and null
+		// match numbers, booleans and nulls; anything else is a string
+		var want interface{}
+		if json.Unmarshal([]byte(value), &want) != nil {
+			want = value
+		}
+		result.Found = reflect.DeepEqual(found, want) == (operator == "eq")
+	case "gt", "lt":
+		number, ok := found.(float64)
+		if !ok {
+			// Only numbers can be compared, a missing value is not found
+			return
+		}
+
+		want, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return result, fmt.Errorf("value is not a number: %s", value)
+		}
+
+		if operator == "gt" {
+			result.Found = number > want
+		} else {
+			result.Found = number < want
+		}
+	default:
+		err = ValidateOperator(operator)
+	}
+	return
+}
+
+func matchRegex(body string, pattern string) (result MatchResult, err error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return
+	}
+
+	matches := re.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	texts := []string{}
+	for _, match := range matches {
+		texts = append(texts, match[0])
+	}
+	result.Text = strings.Join(texts, "\n")
+	result.Found = true
+
+	// Captures come from the first match only
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			result.Captures = append(result.Captures, Capture{name, matches[0][i]})
+		}
+	}
+	return
+}
+
+// matchTexts builds the result for matchers that select nodes: the check
+// finds something if any node was selected and contains the value.
+func matchTexts(texts []string, value string) (result MatchResult) {
+	result.Text = strings.Join(texts, "\n")
+	result.Found = len(texts) > 0 && strings.Contains(result.Text, value)
+	return
+}
+
+// nodeValue returns the attribute value of the node, or its text when no
+// attribute is requested.
+func nodeValue(node *html.Node, attribute string) (string, bool) {
+	if attribute == "" {
+		return nodeText(node), true
+	}
+
+	for _, a := range node.Attr {
+		if a.Key == attribute {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func nodeText(node *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
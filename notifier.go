@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier used for checks that have no notifiers set.
+const DefaultNotifier = "telegram"
+
+// Kinds of events: the check content changed, or fetching it started or
+// stopped failing.
+const (
+	EventChange = "change"
+	EventDown   = "down"
+	EventUp     = "up"
+)
+
+// Priorities of events, mapped to the levels of push notifiers.
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
+// Alert produced by Check.Update, every notifier formats it for its own
+// channel.
+type Event struct {
+	Check     *Check
+	Kind      string
+	Error     string
+	Found     bool
+	Status    int
+	Captures  []Capture
+	Value     *float64
+	LastValue *float64
+	OldHash   string
+	NewHash   string
+	OldText   string
+	NewText   string
+	Time      time.Time
+
+	// Template of the check owner, see Event.Template.
+	UserTemplate string
+}
+
+// Notifier delivers events to a channel. Target is the part of the check
+// notifier after the colon, for example a chat or an address, and is empty
+// when the check names only the notifier.
+type Notifier interface {
+	Notify(event *Event, target string) error
+}
+
+// Notifiers that know their targets can reject a wrong one before it is
+// stored on a check.
+type TargetValidator interface {
+	ValidateTarget(check *Check, target string) error
+}
+
+var (
+	notifiers   = map[string]Notifier{}
+	notifiersMu sync.RWMutex
+)
+
+func RegisterNotifier(name string, notifier Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+
+	notifiers[name] = notifier
+}
+
+// Notifiers of a check are separated by spaces, except the ones in secret
+// references.
+var notifierListRegexp = regexp.MustCompile(`(?:\{\{[^}]*\}\}|\S)+`)
+
+// ParseNotifiers splits the notifiers sent to /updatenotifiers.
+func ParseNotifiers(value string) []string {
+	return notifierListRegexp.FindAllString(value, -1)
+}
+
+// ParseNotifier splits a check notifier like "webhook:ops" into the
+// notifier name and its target.
+func ParseNotifier(value string) (name string, target string) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+func ValidateNotifiers(check *Check, values []string) error {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+
+	for _, value := range values {
+		name, target := ParseNotifier(value)
+		notifier, ok := notifiers[name]
+		if !ok {
+			return fmt.Errorf("unknown notifier: %s, use one of %s", name, strings.Join(notifierNames(), ", "))
+		}
+
+		// A target that is only a secret reference is checked when used
+		if target != "" && secretRefRegexp.FindString(target) == target {
+			continue
+		}
+
+		if validator, ok := notifier.(TargetValidator); ok {
+			if err := validator.ValidateTarget(check, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NotifiersPretty lists the notifiers of the check for /info. Webhook and
+// topic URLs work as credentials, so only their host is shown.
+func (c *Check) NotifiersPretty() string {
+	if len(c.Notifiers) == 0 {
+		return DefaultNotifier
+	}
+
+	values := []string{}
+	for _, value := range c.Notifiers {
+		name, target := ParseNotifier(value)
+		if target != "" {
+			value = name + ":" + maskTarget(target)
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, ", ")
+}
+
+func maskTarget(target string) string {
+	if !strings.Contains(target, "://") {
+		return target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return maskSecret(target)
+	}
+	if strings.Trim(u.Path, "/") == "" && u.RawQuery == "" {
+		return target
+	}
+	return u.Scheme + "://" + u.Host + "/" + maskSecret(target)
+}
+
+// notifierNames lists registered notifiers, callers hold notifiersMu.
+func notifierNames() (names []string) {
+	for name := range notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Notify sends the event to every notifier of the check.
+func Notify(event *Event) {
+	values := event.Check.Notifiers
+	if len(values) == 0 {
+		values = []string{DefaultNotifier}
+	}
+
+	for _, value := range values {
+		name, target := ParseNotifier(value)
+
+		notifiersMu.RLock()
+		notifier, ok := notifiers[name]
+		notifiersMu.RUnlock()
+
+		if !ok {
+			println("unknown notifier", event.Check.ID, name)
+			continue
+		}
+
+		go func(name string, target string) {
+			if err := notifier.Notify(event, target); err != nil {
+				println("error notifying", event.Check.ID, name, err.Error())
+			}
+		}(name, target)
+	}
+}
+
+// Priority is high when the check found what it alerts about, and low for
+// everything else like recoveries.
+func (e *Event) Priority() string {
+	switch e.Kind {
+	case EventDown:
+		return PriorityHigh
+	case EventUp:
+		return PriorityLow
+	}
+
+	if e.Found == e.Check.AlertIfPresent {
+		return PriorityHigh
+	}
+	return PriorityLow
+}
+
+func (e *Event) State() string {
+	switch e.Kind {
+	case EventDown:
+		return "site down"
+	case EventUp:
+		return "site up"
+	}
+
+	if e.Found {
+		return "found"
+	}
+	return "NOT found"
+}
+
+// Sends events to the owner of the check through the bot.
+type TelegramNotifier struct{}
+
+func (n *TelegramNotifier) Notify(event *Event, target string) error {
+	telegramChan <- telegramResponse{event.TelegramMessage(), int64(event.Check.UserID), int64(event.Check.ID)}
+	return nil
+}
+
+func (e *Event) TelegramMessage() string {
+	if e.Kind != EventChange {
+		return fmt.Sprintf("/%d <b>%s</b> <i>%s</i>\n%s", e.Check.ID, html.EscapeString(e.Check.Title), e.State(), html.EscapeString(e.Error))
+	}
+
+	if text := e.Template(); text != "" {
+		message, err := e.Render(text, true)
+		if err == nil {
+			return message
+		}
+		println("error rendering template", e.Check.ID, err.Error())
+	}
+
+	message := fmt.Sprintf("/%d <b>%s</b> <i>%s</i>", e.Check.ID, html.EscapeString(e.Check.Title), e.State())
+
+	if e.Value != nil {
+		message += fmt.Sprintf("\nvalue: %s", FormatNumber(*e.Value))
+		if e.LastValue != nil {
+			message += fmt.Sprintf(" (was %s)", FormatNumber(*e.LastValue))
+		}
+	}
+
+	for _, capture := range e.Captures {
+		message += fmt.Sprintf("\n%s: %s", html.EscapeString(capture.Name), html.EscapeString(capture.Value))
+	}
+
+	if e.OldText != "" {
+		if diff := DiffHTML(e.OldText, e.NewText, MaxDiffLength); diff != "" {
+			message += "\n\n" + diff
+		}
+	}
+	return message
+}
+
+// PlainText describes the event for channels without markup, with the diff
+// cut to maxLength characters.
+func (e *Event) PlainText(maxLength int) string {
+	if e.Kind != EventChange {
+		return fmt.Sprintf("%s %s\n%s\n\n%s", e.Check.Title, e.State(), e.Check.URL, e.Error)
+	}
+
+	if text := e.Template(); text != "" {
+		message, err := e.Render(text, false)
+		if err == nil {
+			return message
+		}
+		println("error rendering template", e.Check.ID, err.Error())
+	}
+
+	text := fmt.Sprintf("%s %s\n%s\n", e.Check.Title, e.State(), e.Check.URL)
+
+	if e.Value != nil {
+		text += fmt.Sprintf("\nvalue: %s", FormatNumber(*e.Value))
+		if e.LastValue != nil {
+			text += fmt.Sprintf(" (was %s)", FormatNumber(*e.LastValue))
+		}
+	}
+
+	for _, capture := range e.Captures {
+		text += fmt.Sprintf("\n%s: %s", capture.Name, capture.Value)
+	}
+
+	if e.OldText != "" {
+		text += "\n\n" + DiffText(e.OldText, e.NewText, maxLength)
+	}
+	return text
+}
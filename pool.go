@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var poolWorkers = flag.Int("workers", 10, "checks fetched at the same time")
+var poolHostWorkers = flag.Int("host-workers", 2, "checks of a single host fetched at the same time")
+var poolHostSpacing = flag.Duration("host-spacing", time.Second, "minimum time between requests to a single host")
+
+// Pool runs fetches with a global limit, a limit per host and a minimum
+// spacing between requests to the same host.
+type Pool struct {
+	sync.Mutex
+	workers     chan struct{}
+	hostWorkers int
+	spacing     time.Duration
+	hosts       map[string]*poolHost
+
+	// Checks queued or being fetched, a check is never updated twice at
+	// the same time.
+	running map[uint64]bool
+	skipped int64
+
+	queued   int
+	done     int64
+	waitSum  time.Duration
+	waitMax  time.Duration
+	lastWait time.Duration
+}
+
+type poolHost struct {
+	workers chan struct{}
+	next    time.Time
+	queued  int
+}
+
+func NewPool(workers int, hostWorkers int, spacing time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if hostWorkers < 1 {
+		hostWorkers = 1
+	}
+
+	return &Pool{
+		workers:     make(chan struct{}, workers),
+		hostWorkers: hostWorkers,
+		spacing:     spacing,
+		hosts:       map[string]*poolHost{},
+		running:     map[uint64]bool{},
+	}
+}
+
+// Update fetches the check on the pool, unless it is already queued or
+// running, in which case the run is skipped.
+func (p *Pool) Update(db *bolt.DB, check *Check) {
+	p.Lock()
+	if p.running[check.ID] {
+		p.skipped++
+		p.Unlock()
+		println("skipping overlapping update for check", check.ID)
+		return
+	}
+	p.running[check.ID] = true
+	p.Unlock()
+
+	defer func() {
+		p.Lock()
+		delete(p.running, check.ID)
+		p.Unlock()
+	}()
+
+	p.Do(check.URL, func() {
+		check.Update(db)
+	})
+}
+
+// Do waits for a free worker for the host of rawURL and runs fn on it.
+func (p *Pool) Do(rawURL string, fn func()) {
+	started := time.Now()
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	p.Lock()
+	h, ok := p.hosts[host]
+	if !ok {
+		h = &poolHost{workers: make(chan struct{}, p.hostWorkers)}
+		p.hosts[host] = h
+	}
+	p.queued++
+	h.queued++
+	p.Unlock()
+
+	h.workers <- struct{}{}
+	defer func() { <-h.workers }()
+
+	p.workers <- struct{}{}
+	defer func() { <-p.workers }()
+
+	// Reserve the next free slot of the host once nothing else can hold
+	// the request back, so queued requests keep their spacing
+	p.Lock()
+	start := time.Now()
+	if h.next.After(start) {
+		start = h.next
+	}
+	h.next = start.Add(p.spacing)
+	p.Unlock()
+
+	time.Sleep(time.Until(start))
+
+	wait := time.Since(started)
+
+	p.Lock()
+	p.queued--
+	h.queued--
+	p.done++
+	p.waitSum += wait
+	p.lastWait = wait
+	if wait > p.waitMax {
+		p.waitMax = wait
+	}
+	p.Unlock()
+
+	fn()
+}
+
+// Status describes the load of the pool for the /status command.
+func (p *Pool) Status() string {
+	p.Lock()
+	defer p.Unlock()
+
+	average := time.Duration(0)
+	if p.done > 0 {
+		average = p.waitSum / time.Duration(p.done)
+	}
+
+	result := fmt.Sprintf("Workers: %d/%d busy\nQueued: %d\nFetched: %d\nSkipped overlapping: %d\nWait: last %s, average %s, max %s",
+		len(p.workers), cap(p.workers), p.queued, p.done, p.skipped,
+		p.lastWait.Round(time.Millisecond), average.Round(time.Millisecond), p.waitMax.Round(time.Millisecond))
+
+	hosts := []string{}
+	for host, h := range p.hosts {
+		if h.queued > 0 || len(h.workers) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		h := p.hosts[host]
+		result += fmt.Sprintf("\n%s: %d/%d busy, %d queued", host, len(h.workers), cap(h.workers), h.queued)
+	}
+	return result
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var ntfyToken = flag.String("ntfy-token", "", "access token for ntfy topics")
+var ntfyPriority = flag.String("ntfy-priority", "high=4,low=2", "ntfy priority of high and low priority alerts")
+var gotifyPriority = flag.String("gotify-priority", "high=8,low=2", "gotify priority of high and low priority alerts")
+var matrixHomeserver = flag.String("matrix-homeserver", "", "Matrix homeserver URL, like https://matrix.org")
+var matrixToken = flag.String("matrix-token", "", "access token of the Matrix user sending alerts")
+
+// Longest diff put into push notifications.
+const MaxPushDiffLength = 1000
+
+// ParsePriorities reads a priority mapping like "high=4,low=2".
+func ParsePriorities(mapping string) (map[string]int, error) {
+	priorities := map[string]int{}
+	for _, pair := range strings.Split(mapping, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("wrong priority: %s", pair)
+		}
+
+		priority, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("wrong priority: %s", pair)
+		}
+		priorities[parts[0]] = priority
+	}
+	return priorities, nil
+}
+
+// Publishes events to ntfy topics, the target is the topic URL like
+// https://ntfy.sh/mytopic.
+type NtfyNotifier struct {
+	DB         *bolt.DB
+	Token      string
+	Priorities map[string]int
+}
+
+func (n *NtfyNotifier) ValidateTarget(check *Check, target string) error {
+	if !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://") {
+		return fmt.Errorf("please set the topic url, like ntfy:https://ntfy.sh/topic")
+	}
+	return nil
+}
+
+func (n *NtfyNotifier) Notify(event *Event, target string) error {
+	target, err := event.Check.expandSecrets(n.DB, target)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", target, strings.NewReader(event.PlainText(MaxPushDiffLength)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Title", fmt.Sprintf("%s %s", event.Check.Title, event.State()))
+	req.Header.Set("Click", event.Check.URL)
+	if priority, ok := n.Priorities[event.Priority()]; ok {
+		req.Header.Set("Priority", strconv.Itoa(priority))
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	return doRequest(req)
+}
+
+// Sends events to a Gotify server, the target is the message URL with the
+// application token like https://gotify.example.com/message?token=...,
+// which may be a secret reference.
+type GotifyNotifier struct {
+	DB         *bolt.DB
+	Priorities map[string]int
+}
+
+func (n *GotifyNotifier) ValidateTarget(check *Check, target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Query().Get("token") == "" {
+		return fmt.Errorf("please set the message url with the application token, like gotify:https://gotify.example.com/message?token=...")
+	}
+	return nil
+}
+
+func (n *GotifyNotifier) Notify(event *Event, target string) error {
+	target, err := event.Check.expandSecrets(n.DB, target)
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"title":   fmt.Sprintf("%s %s", event.Check.Title, event.State()),
+		"message": event.PlainText(MaxPushDiffLength),
+		"extras": map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": event.Check.URL},
+			},
+		},
+	}
+	if priority, ok := n.Priorities[event.Priority()]; ok {
+		message["priority"] = priority
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doRequest(req)
+}
+
+// Sends events to Matrix rooms, the target is the room ID like
+// !room:matrix.org. High priority events are sent as text messages, low
+// priority ones as notices that usually don't ping.
+type MatrixNotifier struct {
+	Homeserver string
+	Token      string
+}
+
+func (n *MatrixNotifier) ValidateTarget(check *Check, target string) error {
+	if !strings.HasPrefix(target, "!") || !strings.Contains(target, ":") {
+		return fmt.Errorf("please set the room id, like matrix:!room:matrix.org")
+	}
+	return nil
+}
+
+func (n *MatrixNotifier) Notify(event *Event, target string) error {
+	msgtype := "m.text"
+	if event.Priority() == PriorityLow {
+		msgtype = "m.notice"
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"msgtype":        msgtype,
+		"body":           event.PlainText(MaxPushDiffLength),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": event.MatrixHTML(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Matrix needs a transaction ID per message, alerts are not retried so
+	// a new one is made every time
+	txn := fmt.Sprintf("gourlwatcher%d", time.Now().UnixNano())
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", strings.TrimSuffix(n.Homeserver, "/"), url.PathEscape(target), txn)
+
+	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+n.Token)
+
+	return doRequest(req)
+}
+
+// MatrixHTML is the email body with the tags Matrix clients render.
+func (e *Event) MatrixHTML() string {
+	message := strings.Replace(e.EmailHTML(), "<ins>", "<u>", -1)
+	return strings.Replace(message, "</ins>", "</u>", -1)
+}
+
+func doRequest(req *http.Request) error {
+	client := http.Client{
+		Timeout: time.Duration(10 * time.Second),
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL may hold tokens
+		return fmt.Errorf("request failed: %s", failureReason(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
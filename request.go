@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Shorthands accepted by /updatecontenttype.
+var contentTypes = map[string]string{
+	"json": "application/json",
+	"form": "application/x-www-form-urlencoded",
+	"xml":  "application/xml",
+	"text": "text/plain",
+}
+
+var requestMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+
+// Parts of header names and body fields whose values are masked in /info.
+var secretNames = []string{"auth", "token", "secret", "password", "passwd", "key", "cookie", "session"}
+
+// NewRequest builds the request of the check from its method, headers and
+// body, with the secrets they reference filled in. GET and HEAD requests are
+// conditional once the site sent an ETag or Last-Modified.
+func (c *Check) NewRequest(db *bolt.DB) (*http.Request, error) {
+	method := c.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	url, err := c.expandSecrets(db, c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if c.Body != "" {
+		var body string
+		if body, err = c.expandSecrets(db, c.Body); err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequest(method, url, strings.NewReader(body))
+	} else {
+		req, err = http.NewRequest(method, url, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ContentType != "" {
+		req.Header.Set("Content-Type", c.ContentType)
+	}
+
+	// Ask for the page only when it changed since the last fetch
+	if method == "GET" || method == "HEAD" {
+		if c.ETag != "" {
+			req.Header.Set("If-None-Match", c.ETag)
+		}
+		if c.LastModified != "" {
+			req.Header.Set("If-Modified-Since", c.LastModified)
+		}
+	}
+
+	for name, value := range c.Headers {
+		if value, err = c.expandSecrets(db, value); err != nil {
+			return nil, err
+		}
+
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// ParseMethod checks the HTTP method, empty means GET.
+func ParseMethod(value string) (string, error) {
+	method := strings.ToUpper(strings.TrimSpace(value))
+	if method == "" {
+		return "", nil
+	}
+
+	for _, m := range requestMethods {
+		if m == method {
+			return method, nil
+		}
+	}
+	return "", fmt.Errorf("wrong method %s, use one of %s", value, strings.Join(requestMethods, ", "))
+}
+
+// ParseHeaders reads one "Name: value" header per line.
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("please send one header per line like\nName: value")
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(parts[1])
+	}
+
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	return headers, nil
+}
+
+// ParseContentType expands the shorthands and checks the media type.
+func ParseContentType(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	if full, ok := contentTypes[strings.ToLower(value)]; ok {
+		return full, nil
+	}
+
+	if _, _, err := mime.ParseMediaType(value); err != nil {
+		return "", fmt.Errorf("wrong content type %s: %s", value, err.Error())
+	}
+	return value, nil
+}
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range secretNames {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "****"
+}
+
+// HeadersPretty lists the headers of the check, one per line, with secret
+// values masked.
+func (c *Check) HeadersPretty() string {
+	names := []string{}
+	for name := range c.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		value := c.Headers[name]
+		if isSecretName(name) {
+			value = maskSecret(value)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, value))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// BodyPretty is the request body with the values of secret form or JSON
+// fields masked. Without a content type the body is masked by what it looks
+// like, other bodies may hold secrets anywhere and only their size is shown.
+func (c *Check) BodyPretty() string {
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType)
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if body, ok := maskForm(c.Body); ok {
+			return body
+		}
+	case strings.HasSuffix(mediaType, "json"):
+		if body, ok := maskJSONBody(c.Body); ok {
+			return body
+		}
+	case c.ContentType == "":
+		if body, ok := maskJSONBody(c.Body); ok {
+			return body
+		}
+		if strings.Contains(c.Body, "=") {
+			if body, ok := maskForm(c.Body); ok {
+				return body
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s, not shown", FormatSize(int64(len(c.Body))))
+}
+
+func maskForm(text string) (string, bool) {
+	values, err := url.ParseQuery(strings.TrimSpace(text))
+	if err != nil {
+		return "", false
+	}
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := []string{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			if isSecretName(name) {
+				value = maskSecret(value)
+			} else {
+				value = url.QueryEscape(value)
+			}
+			fields = append(fields, url.QueryEscape(name)+"="+value)
+		}
+	}
+	return strings.Join(fields, "&"), true
+}
+
+func maskJSONBody(text string) (string, bool) {
+	var body interface{}
+	if err := json.Unmarshal([]byte(text), &body); err != nil {
+		return "", false
+	}
+	data, err := json.Marshal(maskJSON(body))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func maskJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if _, ok := field.(string); ok && isSecretName(name) {
+				v[name] = maskSecret(field.(string))
+			} else {
+				v[name] = maskJSON(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskJSON(v[i])
+		}
+	}
+	return value
+}
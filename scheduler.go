@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/robfig/cron"
+)
+
+// Schedule of new checks, every minute.
+const DefaultSchedule = "0 * * * * *"
+
+// Scheduler owns the cron jobs of the checks, one per enabled check.
+type Scheduler struct {
+	sync.Mutex
+	db   *bolt.DB
+	pool *Pool
+	cron *cron.Cron
+	jobs map[uint64]*checkJob
+}
+
+// checkJob runs a check, unless it was removed or replaced while cron was
+// starting it.
+type checkJob struct {
+	scheduler *Scheduler
+	id        uint64
+	spec      string
+	schedule  cron.Schedule
+}
+
+func (j *checkJob) Run() {
+	if !j.scheduler.active(j) {
+		return
+	}
+	TryUpdate(j.scheduler.db, j.scheduler.pool, j.id)
+}
+
+func NewScheduler(db *bolt.DB, pool *Pool) *Scheduler {
+	s := &Scheduler{
+		db:   db,
+		pool: pool,
+		cron: cron.New(),
+		jobs: map[uint64]*checkJob{},
+	}
+	s.cron.Start()
+	return s
+}
+
+func (s *Scheduler) Stop() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.cron.Stop()
+}
+
+// Set adds the job of an enabled check, replaces it when the schedule
+// changed, and removes it when the check is disabled.
+func (s *Scheduler) Set(check *Check) error {
+	if !check.IsEnabled {
+		s.Remove(check.ID)
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if job, ok := s.jobs[check.ID]; ok && job.spec == check.Schedule {
+		return nil
+	}
+
+	schedule, err := cron.Parse(check.Schedule)
+	if err != nil {
+		return err
+	}
+
+	job := &checkJob{scheduler: s, id: check.ID, spec: check.Schedule, schedule: schedule}
+	if _, ok := s.jobs[check.ID]; ok {
+		s.jobs[check.ID] = job
+		s.rebuild()
+		return nil
+	}
+
+	s.jobs[check.ID] = job
+	s.cron.Schedule(schedule, job)
+	return nil
+}
+
+// Remove drops the job of the check, if any.
+func (s *Scheduler) Remove(id uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return
+	}
+
+	delete(s.jobs, id)
+	s.rebuild()
+}
+
+// active tells if the job is still the current one of its check.
+func (s *Scheduler) active(job *checkJob) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.jobs[job.id] == job
+}
+
+// Next is the time the check runs next, zero when it has no job.
+func (s *Scheduler) Next(id uint64) time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return time.Time{}
+	}
+
+	for _, entry := range s.cron.Entries() {
+		if entry.Job == job {
+			return entry.Next
+		}
+	}
+	return time.Time{}
+}
+
+// rebuild replaces the cron with a new one running the current jobs, as
+// cron can't remove entries. The jobs keep the time of their next run.
+func (s *Scheduler) rebuild() {
+	next := map[cron.Job]time.Time{}
+	for _, entry := range s.cron.Entries() {
+		next[entry.Job] = entry.Next
+	}
+	s.cron.Stop()
+
+	s.cron = cron.New()
+	for _, job := range s.jobs {
+		s.cron.Schedule(&resumedSchedule{next: next[job], schedule: job.schedule}, job)
+	}
+	s.cron.Start()
+}
+
+// resumedSchedule runs first at the time the replaced entry was due, then
+// follows its schedule, so @every intervals don't start over.
+type resumedSchedule struct {
+	next     time.Time
+	schedule cron.Schedule
+}
+
+func (r *resumedSchedule) Next(t time.Time) time.Time {
+	if !r.next.IsZero() {
+		next := r.next
+		r.next = time.Time{}
+		return next
+	}
+	return r.schedule.Next(t)
+}
+
+// Intervals offered on the keyboard of a check.
+var schedulePresets = []struct {
+	Title string
+	Spec  string
+}{
+	{"1m", DefaultSchedule},
+	{"5m", "@every 5m"},
+	{"15m", "@every 15m"},
+	{"1h", "@hourly"},
+	{"1d", "@daily"},
+}
+
+// SetSchedule shows when the check runs, or changes its cron spec when one
+// is given and reschedules it right away.
+func (c *Check) SetSchedule(db *bolt.DB, scheduler *Scheduler, requester int64, findID string, spec string) (result string) {
+	check := c.Get(db, findID)
+	if check == nil {
+		return "wrong id"
+	}
+
+	if requester != int64(check.UserID) {
+		return "Not your check"
+	}
+
+	if spec != "" {
+		if _, err := cron.Parse(spec); err != nil {
+			return "wrong schedule: " + err.Error()
+		}
+
+		check.Schedule = spec
+		if err := db.Update(check.put); err != nil {
+			return err.Error()
+		}
+
+		if err := scheduler.Set(check); err != nil {
+			return err.Error()
+		}
+		result = "Edited\n\n"
+	}
+
+	result += fmt.Sprintf("Schedule: %s", check.Schedule)
+	if next := scheduler.Next(check.ID); !next.IsZero() {
+		result += fmt.Sprintf("\nNext run: %s", next.Format("Jan 2, 2006 at 3:04:05pm (MST)"))
+	} else if !check.IsEnabled {
+		result += "\nDisabled"
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var secretNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// References to secrets, Telegram clients may turn their quotes into
+// typographic ones.
+var secretRefRegexp = regexp.MustCompile(`\{\{\s*secret\s+["“”«»]([A-Za-z0-9_-]+)["“”«»]\s*\}\}`)
+
+// Helper struct for serialization, the value is encrypted.
+type Secret struct {
+	Name        string    `json:"name"`
+	UserID      int64     `json:"user_id"`
+	Value       string    `json:"value"`
+	LastChanged time.Time `json:"last_changed"`
+}
+
+func secretKey(userID int64, name string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", userID, name))
+}
+
+func (s *Secret) New(db *bolt.DB, requester int64, name string, value string) (result string) {
+	if !secretNameRegexp.MatchString(name) {
+		return "wrong secret name, use letters, digits, - and _"
+	}
+	if len(value) == 0 {
+		return "missing secret value"
+	}
+
+	encrypted, err := Encrypt([]byte(value))
+	if err != nil {
+		return err.Error()
+	}
+
+	secret := Secret{
+		Name:        name,
+		UserID:      requester,
+		Value:       encrypted,
+		LastChanged: time.Now(),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(secret)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(SecretsBucket).Put(secretKey(requester, name), data)
+	})
+
+	if err != nil {
+		return "error inserting new item"
+	}
+
+	return fmt.Sprintf("secret %s saved, use {{secret \"%s\"}} in urls, headers and bodies", name, name)
+}
+
+func (s *Secret) Delete(db *bolt.DB, requester int64, name string) (result bool) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		key := secretKey(requester, name)
+		if tx.Bucket(SecretsBucket).Get(key) == nil {
+			return fmt.Errorf("no such secret: %s", name)
+		}
+
+		return tx.Bucket(SecretsBucket).Delete(key)
+	})
+
+	if err != nil {
+		println(err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// Reveal returns the decrypted value of the secret of the user.
+func (s *Secret) Reveal(db *bolt.DB, userID int64, name string) (string, error) {
+	secret := &Secret{}
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(SecretsBucket).Get(secretKey(userID, name))
+		if data == nil {
+			return fmt.Errorf("no such secret: %s", name)
+		}
+
+		return json.Unmarshal(data, secret)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	value, err := Decrypt(secret.Value)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting secret %s: %s", name, err.Error())
+	}
+	return string(value), nil
+}
+
+// SecretsList shows the names of the secrets of the user, never the values.
+func SecretsList(db *bolt.DB, requester int64) (result string) {
+	prefix := []byte(fmt.Sprintf("%d:", requester))
+
+	names := []string{}
+	db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(SecretsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			secret := &Secret{}
+			if err := json.Unmarshal(v, secret); err != nil {
+				println("error unmarshaling json", err)
+				continue
+			}
+
+			names = append(names, fmt.Sprintf("%s (changed %s)", secret.Name, secret.LastChanged.Format("Jan 2, 2006 at 3:04pm (MST)")))
+		}
+		return nil
+	})
+
+	if len(names) == 0 {
+		return "no secrets, add one with /addsecret name value"
+	}
+	return "Secrets:\n" + strings.Join(names, "\n")
+}
+
+// expandSecrets replaces {{secret "name"}} references with the values of
+// the secrets of the check owner, the rest of the text is kept as is.
+func (c *Check) expandSecrets(db *bolt.DB, text string) (string, error) {
+	var err error
+	expanded := secretRefRegexp.ReplaceAllStringFunc(text, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+
+		s := &Secret{}
+		value, revealErr := s.Reveal(db, int64(c.UserID), secretRefRegexp.FindStringSubmatch(ref)[1])
+		if revealErr != nil {
+			err = revealErr
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
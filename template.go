@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Fields available to alert templates, for example
+// {{.Title}} {{.State}}: {{index .Captures "seats"}} seats left
+type TemplateData struct {
+	ID        uint64
+	Title     string
+	URL       string
+	State     string
+	Found     bool
+	Status    int
+	Value     string
+	LastValue string
+	Captures  map[string]string
+	Diff      string
+	Time      string
+}
+
+var templateFuncs = template.FuncMap{
+	"short": Short,
+}
+
+// Template used for the alert, the check template wins over the one of the
+// user.
+func (e *Event) Template() string {
+	if e.Check.Template != "" {
+		return e.Check.Template
+	}
+	return e.UserTemplate
+}
+
+// Render executes the template of the event. Values are escaped for
+// Telegram HTML when escape is set, and the diff is rendered to match.
+func (e *Event) Render(text string, escape bool) (string, error) {
+	tmpl, err := template.New("alert").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	quote := func(s string) string { return s }
+	diff := DiffText
+	if escape {
+		quote = html.EscapeString
+		diff = DiffHTML
+	}
+
+	data := TemplateData{
+		ID:       e.Check.ID,
+		Title:    quote(e.Check.Title),
+		URL:      quote(e.Check.URL),
+		State:    e.State(),
+		Found:    e.Found,
+		Status:   e.Status,
+		Captures: map[string]string{},
+		Time:     e.Time.Format("Jan 2, 2006 at 3:04pm (MST)"),
+	}
+
+	if e.Value != nil {
+		data.Value = FormatNumber(*e.Value)
+	}
+	if e.LastValue != nil {
+		data.LastValue = FormatNumber(*e.LastValue)
+	}
+	for _, capture := range e.Captures {
+		data.Captures[capture.Name] = quote(capture.Value)
+	}
+	if e.OldText != "" {
+		data.Diff = diff(e.OldText, e.NewText, MaxDiffLength)
+	}
+
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// previewEvent is an alert built from the stored state of the check, used
+// to try templates out.
+func previewEvent(check *Check, userTemplate string) *Event {
+	return &Event{
+		Check:        check,
+		Kind:         EventChange,
+		Found:        check.AlertIfPresent,
+		Status:       http.StatusOK,
+		Value:        check.LastValue,
+		NewText:      check.Content,
+		Time:         time.Now(),
+		UserTemplate: userTemplate,
+	}
+}
+
+func templateReply(event *Event) (result string) {
+	text := event.Template()
+	if text == "" {
+		return "Template: default\n\nPreview:\n" + event.TelegramMessage()
+	}
+
+	label := "Template"
+	if event.Check.Template == "" {
+		label = "Template of the user"
+	}
+
+	preview, err := event.Render(text, true)
+	if err != nil {
+		return "wrong template: " + html.EscapeString(err.Error())
+	}
+	return fmt.Sprintf("%s:\n<code>%s</code>\n\nPreview:\n%s", label, html.EscapeString(text), preview)
+}
+
+// SetTemplate shows the alert template of the check, or sets it when text
+// is given. "default" removes it.
+func (c *Check) SetTemplate(db *bolt.DB, requester int64, findID string, text string) (result string) {
+	check := c.Get(db, findID)
+	if check == nil {
+		return "wrong id"
+	}
+
+	if requester != int64(check.UserID) {
+		return "Not your check"
+	}
+	check.Content = check.loadContent(db)
+
+	user := &User{}
+	userTemplate := ""
+	if user = user.Get(db, check.UserID); user != nil {
+		userTemplate = user.Template
+	}
+
+	if text == "" {
+		return templateReply(previewEvent(check, userTemplate))
+	}
+
+	if strings.TrimSpace(text) == "default" {
+		text = ""
+	}
+
+	event := previewEvent(check, userTemplate)
+	event.Check.Template = text
+	if _, err := event.Render(text, true); err != nil {
+		return "wrong template: " + html.EscapeString(err.Error())
+	}
+
+	if err := db.Update(check.put); err != nil {
+		return err.Error()
+	}
+
+	return "Edited\n\n" + templateReply(event)
+}
+
+// SetTemplate shows the alert template used for checks of the user without
+// their own, or sets it when text is given. "default" removes it.
+func (c *User) SetTemplate(db *bolt.DB, id uint64, text string) (result string) {
+	user := c.Get(db, id)
+	if user == nil {
+		return "Not authorized"
+	}
+
+	sample := &Check{ID: 1, Title: "Example", URL: "https://example.com/", AlertIfPresent: true}
+
+	if text == "" {
+		return templateReply(previewEvent(sample, user.Template))
+	}
+
+	if strings.TrimSpace(text) == "default" {
+		text = ""
+	}
+
+	event := previewEvent(sample, text)
+	if _, err := event.Render(text, true); err != nil {
+		return "wrong template: " + html.EscapeString(err.Error())
+	}
+
+	user.Template = text
+	err := db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(UsersBucket).Put(KeyFor(id), data)
+	})
+
+	if err != nil {
+		return err.Error()
+	}
+
+	return "Edited\n\n" + templateReply(event)
+}
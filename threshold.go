@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Conditions a check can apply to the number extracted by its matcher,
+// for example "below 100", "above 10", "change 5%", "increased" or
+// "decreased".
+var Conditions = []string{"below", "above", "change", "increased", "decreased"}
+
+// A number with the separators that may follow its digits: commas and dots
+// before any digits, spaces only before a group of exactly 3 digits.
+// normalizeNumber decides which of them belong to the number.
+var numberRegexp = regexp.MustCompile(`-?\d+(?:[.,]\d+|[ \x{00a0}]\d{3}\b)*`)
+
+var separatorRegexp = regexp.MustCompile(`[^\d-]`)
+
+func ValidateThreshold(threshold string) error {
+	_, _, err := parseThreshold(threshold)
+	return err
+}
+
+func parseThreshold(threshold string) (condition string, limit float64, err error) {
+	fields := strings.Fields(threshold)
+	if len(fields) == 0 {
+		return "", 0, fmt.Errorf("missing condition, use one of %s", strings.Join(Conditions, ", "))
+	}
+
+	condition = fields[0]
+	switch condition {
+	case "increased", "decreased":
+		if len(fields) != 1 {
+			return "", 0, fmt.Errorf("%s takes no value", condition)
+		}
+	case "below", "above", "change":
+		if len(fields) != 2 {
+			return "", 0, fmt.Errorf("please send in format\n%s number", condition)
+		}
+
+		number := fields[1]
+		if condition == "change" {
+			number = strings.TrimSuffix(number, "%")
+		}
+
+		limit, err = strconv.ParseFloat(number, 64)
+		if err != nil {
+			return "", 0, fmt.Errorf("not a number: %s", fields[1])
+		}
+	default:
+		return "", 0, fmt.Errorf("unknown condition: %s, use one of %s", condition, strings.Join(Conditions, ", "))
+	}
+	return
+}
+
+// ThresholdReached tells whether the value satisfies the check threshold.
+// Relative conditions compare with LastValue and never hold on the first
+// value.
+func (c *Check) ThresholdReached(value float64) (bool, error) {
+	condition, limit, err := parseThreshold(c.Threshold)
+	if err != nil {
+		return false, err
+	}
+
+	switch condition {
+	case "below":
+		return value < limit, nil
+	case "above":
+		return value > limit, nil
+	}
+
+	if c.LastValue == nil {
+		return false, nil
+	}
+	last := *c.LastValue
+
+	switch condition {
+	case "increased":
+		return value > last, nil
+	case "decreased":
+		return value < last, nil
+	case "change":
+		if last == 0 {
+			return value != 0, nil
+		}
+		return math.Abs(value-last)/math.Abs(last)*100 > limit, nil
+	}
+	return false, nil
+}
+
+// NumberFrom returns the number the matcher extracted: the capture named
+// "value" or the first named group for regex matchers, the extracted text
+// otherwise.
+func NumberFrom(match MatchResult) (float64, error) {
+	text := match.Text
+	for i, capture := range match.Captures {
+		if i == 0 || capture.Name == "value" {
+			text = capture.Value
+		}
+	}
+
+	return ParseNumber(text)
+}
+
+// ParseNumber reads the first number in the text. JSON numbers and arrays
+// are read as JSON. Otherwise thousands separators like "1,299.00",
+// "1.299,00" or "1 299" and decimal commas like "12,50" are accepted.
+// Prices are often grouped with spaces, so "100 200" is one number too; a
+// space only groups when at most 3 digits precede the first one.
+func ParseNumber(text string) (float64, error) {
+	var data interface{}
+	if json.Unmarshal([]byte(text), &data) == nil {
+		if list, ok := data.([]interface{}); ok && len(list) > 0 {
+			data = list[0]
+		}
+		if number, ok := data.(float64); ok {
+			return number, nil
+		}
+	}
+
+	number := numberRegexp.FindString(text)
+	if number == "" {
+		return 0, fmt.Errorf("no number in %q", Short(text, 50))
+	}
+
+	return strconv.ParseFloat(normalizeNumber(number), 64)
+}
+
+// normalizeNumber drops the thousands separators of a number found by
+// numberRegexp and turns its decimal separator into a dot. The decimal
+// separator is the last of comma and dot when both are used; a lone comma
+// is one unless exactly 3 digits follow it, a lone dot always is.
+func normalizeNumber(number string) string {
+	groups := separatorRegexp.Split(number, -1)
+	separators := separatorRegexp.FindAllString(number, -1)
+
+	decimal := ""
+	commas := strings.Count(number, ",")
+	dots := strings.Count(number, ".")
+	switch {
+	case commas > 0 && dots > 0:
+		decimal = separators[len(separators)-1]
+	case dots == 1:
+		decimal = "."
+	case commas == 1 && len(groups[len(groups)-1]) != 3:
+		decimal = ","
+	}
+
+	result := groups[0]
+	for i, separator := range separators {
+		group := groups[i+1]
+		if separator == decimal {
+			return result + "." + group
+		}
+		if len(group) != 3 || (i == 0 && separator != "," && separator != "." && len(groups[0]) > 3) {
+			// Not a thousands group, the number ends here
+			break
+		}
+		result += group
+	}
+	return result
+}
+
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
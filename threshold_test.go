@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"42", 42},
+		{"-5", -5},
+		{"1e3", 1000},
+		{"[3, 4]", 3},
+		{"12.5", 12.5},
+		{"12,50", 12.5},
+		{"1.299", 1.299},
+		{"1,299", 1299},
+		{"1,299.00", 1299},
+		{"1.299,00", 1299},
+		{"1,234,567.89", 1234567.89},
+		{"1 299", 1299},
+		{"1 299,90", 1299.9},
+		{"Price: $1,299.99 today", 1299.99},
+		{"10 items, 20 left", 10},
+		{"1 2", 1},
+		{"12,5 and 6", 12.5},
+		// Spaces group thousands, so two numbers read as one
+		{"100 200", 100200},
+		// Unless more than 3 digits precede them
+		{"2024 100", 2024},
+	}
+
+	for _, test := range tests {
+		got, err := ParseNumber(test.text)
+		if err != nil {
+			t.Errorf("ParseNumber(%q) failed: %s", test.text, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseNumber(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseNumberWithoutNumber(t *testing.T) {
+	for _, text := range []string{"", "sold out", "-"} {
+		if _, err := ParseNumber(text); err == nil {
+			t.Errorf("ParseNumber(%q) should fail", text)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// Delivery attempts for an event before giving up.
+	WebhookAttempts = 5
+
+	// Wait before the first retry, doubled after every failed attempt.
+	WebhookBackoff = 2 * time.Second
+
+	// Delivery attempts kept for every webhook.
+	DeliveryLimit = 100
+
+	// Header with the HMAC-SHA256 of the request body, keyed by the webhook
+	// secret.
+	SignatureHeader = "X-Gourlwatcher-Signature"
+)
+
+// Helper struct for serialization.
+type Webhook struct {
+	Name   string `json:"name"`
+	UserID int64  `json:"user_id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// Helper struct for serialization.
+type Delivery struct {
+	ID      uint64    `json:"id"`
+	CheckID uint64    `json:"check_id"`
+	Time    time.Time `json:"time"`
+	Attempt int       `json:"attempt"`
+	Status  int       `json:"status"`
+	Error   string    `json:"error"`
+}
+
+// Body of the request sent to webhooks.
+type WebhookPayload struct {
+	Event          string            `json:"event"`
+	Error          string            `json:"error,omitempty"`
+	CheckID        uint64            `json:"check_id"`
+	Title          string            `json:"title"`
+	URL            string            `json:"url"`
+	Found          bool              `json:"found"`
+	State          string            `json:"state"`
+	OldHash        string            `json:"old_hash"`
+	NewHash        string            `json:"new_hash"`
+	Value          *float64          `json:"value,omitempty"`
+	LastValue      *float64          `json:"last_value,omitempty"`
+	Captures       map[string]string `json:"captures,omitempty"`
+	PreviousChange time.Time         `json:"previous_change"`
+	Time           time.Time         `json:"time"`
+}
+
+func webhookKey(userID int64, name string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", userID, name))
+}
+
+func (w *Webhook) New(db *bolt.DB, requester int64, name string, url string, secret string) (result string) {
+	if len(name) == 0 || strings.Contains(name, ":") {
+		return "wrong webhook name"
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "wrong webhook url"
+	}
+
+	webhook := Webhook{
+		Name:   name,
+		UserID: requester,
+		URL:    url,
+		Secret: secret,
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(webhook)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(WebhooksBucket).Put(webhookKey(requester, name), data)
+	})
+
+	if err != nil {
+		return "error inserting new item"
+	}
+
+	return fmt.Sprintf("webhook %s added, use webhook:%s in /updatenotifiers", name, name)
+}
+
+func (w *Webhook) Delete(db *bolt.DB, requester int64, name string) (result bool) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		key := webhookKey(requester, name)
+		if tx.Bucket(WebhooksBucket).Get(key) == nil {
+			return fmt.Errorf("no such webhook: %s", name)
+		}
+
+		if err := tx.Bucket(WebhooksBucket).Delete(key); err != nil {
+			return err
+		}
+
+		if tx.Bucket(DeliveriesBucket).Bucket(key) != nil {
+			return tx.Bucket(DeliveriesBucket).DeleteBucket(key)
+		}
+		return nil
+	})
+
+	if err != nil {
+		println(err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (w *Webhook) Get(db *bolt.DB, userID int64, name string) (result *Webhook) {
+	webhook := &Webhook{}
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(WebhooksBucket).Get(webhookKey(userID, name))
+		if data == nil {
+			return fmt.Errorf("no such webhook: %s", name)
+		}
+
+		return json.Unmarshal(data, webhook)
+	})
+
+	if err != nil {
+		println(err.Error(), http.StatusBadRequest)
+		return
+	}
+	return webhook
+}
+
+func GetMyWebhooks(db *bolt.DB, requester int64, output *[]*Webhook) error {
+	prefix := []byte(fmt.Sprintf("%d:", requester))
+
+	return db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(WebhooksBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			webhook := &Webhook{}
+			if err := json.Unmarshal(v, webhook); err != nil {
+				println("error unmarshaling json", err)
+				continue
+			}
+
+			*output = append(*output, webhook)
+		}
+		return nil
+	})
+}
+
+// LastDelivery returns the latest delivery attempt, nil when nothing was
+// sent yet.
+func (w *Webhook) LastDelivery(db *bolt.DB) (result *Delivery) {
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(DeliveriesBucket).Bucket(webhookKey(w.UserID, w.Name))
+		if b == nil {
+			return nil
+		}
+
+		b.ForEach(func(k, v []byte) error {
+			delivery := &Delivery{}
+			if err := json.Unmarshal(v, delivery); err != nil {
+				println("error unmarshaling json", err)
+				return nil
+			}
+
+			if result == nil || delivery.ID > result.ID {
+				result = delivery
+			}
+			return nil
+		})
+		return nil
+	})
+	return
+}
+
+func (w *Webhook) saveDelivery(db *bolt.DB, delivery *Delivery) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(DeliveriesBucket).CreateBucketIfNotExists(webhookKey(w.UserID, w.Name))
+		if err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		delivery.ID = seq
+
+		data, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+
+		if err = b.Put(KeyFor(seq), data); err != nil {
+			return err
+		}
+
+		// Sequences only grow, so everything below the limit is old
+		if seq > DeliveryLimit {
+			return b.Delete(KeyFor(seq - DeliveryLimit))
+		}
+		return nil
+	})
+}
+
+// Sign returns the signature of the body sent in SignatureHeader.
+func (w *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *Event) CaptureMap() map[string]string {
+	if len(e.Captures) == 0 {
+		return nil
+	}
+
+	captures := map[string]string{}
+	for _, capture := range e.Captures {
+		captures[capture.Name] = capture.Value
+	}
+	return captures
+}
+
+// Posts events to the webhooks registered with /addwebhook.
+type WebhookNotifier struct {
+	DB *bolt.DB
+}
+
+func (n *WebhookNotifier) ValidateTarget(check *Check, target string) error {
+	webhook := &Webhook{}
+	if webhook.Get(n.DB, int64(check.UserID), target) == nil {
+		return fmt.Errorf("no such webhook: %s", target)
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) Notify(event *Event, target string) error {
+	webhook := &Webhook{}
+	webhook = webhook.Get(n.DB, int64(event.Check.UserID), target)
+	if webhook == nil {
+		return fmt.Errorf("no such webhook: %s", target)
+	}
+
+	body, err := json.Marshal(WebhookPayload{
+		Event:          event.Kind,
+		Error:          event.Error,
+		CheckID:        event.Check.ID,
+		Title:          event.Check.Title,
+		URL:            event.Check.URL,
+		Found:          event.Found,
+		State:          event.State(),
+		OldHash:        event.OldHash,
+		NewHash:        event.NewHash,
+		Value:          event.Value,
+		LastValue:      event.LastValue,
+		Captures:       event.CaptureMap(),
+		PreviousChange: event.Check.LastChanged,
+		Time:           event.Time,
+	})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{
+		Timeout: time.Duration(10 * time.Second),
+	}
+
+	backoff := WebhookBackoff
+	for attempt := 1; ; attempt++ {
+		delivery := &Delivery{
+			CheckID: event.Check.ID,
+			Time:    time.Now(),
+			Attempt: attempt,
+		}
+
+		err = webhook.post(&client, body, delivery)
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		if err := webhook.saveDelivery(n.DB, delivery); err != nil {
+			println("error saving delivery", webhook.Name, err.Error())
+		}
+
+		if err == nil || attempt == WebhookAttempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) post(client *http.Client, body []byte, delivery *Delivery) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, w.Sign(body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	delivery.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func WebhooksList(db *bolt.DB, requester int64) (result string) {
+	var webhooks []*Webhook
+	if err := GetMyWebhooks(db, requester, &webhooks); err != nil {
+		println("error loading webhooks", err)
+		return err.Error()
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Name < webhooks[j].Name
+	})
+
+	for _, v := range webhooks {
+		state := "never sent"
+		if delivery := v.LastDelivery(db); delivery != nil {
+			state = fmt.Sprintf("last sent %s (%d)", delivery.Time.Format("Jan 2, 2006 at 3:04pm (MST)"), delivery.Status)
+			if delivery.Error != "" {
+				state += " " + delivery.Error
+			}
+		}
+		result += fmt.Sprintf("\n\n<b>%s</b> %s\n%s", html.EscapeString(v.Name), html.EscapeString(v.URL), html.EscapeString(state))
+	}
+
+	if result == "" {
+		return "Empty list"
+	}
+	return result
+}